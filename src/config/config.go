@@ -4,19 +4,219 @@
 package config
 
 import (
+	"k8s.io/apimachinery/pkg/labels"
+	"net"
+	"sync"
 	"time"
 )
 
 type Config struct {
-	ClustersToWatch     []string
-	ClusterToApply      string
-	NamespaceToWatch    string
-	NamespacesToExclude []string
+	ClustersToWatch   []ClusterConfig
+	ClusterToApply    string
+	LocalPodCIDRs     []*net.IPNet
+	NamespacesToWatch []string
+	// NamespaceRules decide in order which namespaces are replicated.
+	NamespaceRules      []NamespaceRule
 	ReplicatedLabelVal  string
 	WatchNamespaces     bool
 	WatchEndpoints      bool
+	WatchEndpointSlices bool
 	WatchServices       bool
 	ResyncPeriod        time.Duration
+	Workers             int
+	MaxRetries          int
+	RetryBaseDelay      time.Duration
+	RetryMaxDelay       time.Duration
+	LeaderElection      bool
+	LeaseName           string
+	LeaseNamespace      string
+	LeaseIdentity       string
+	LeaseDuration       time.Duration
+	RenewDeadline       time.Duration
+	RetryPeriod         time.Duration
+	MetricsAddr         string
+	GCPeriod            time.Duration
+	DryRun              bool
+	// ExportOptIn is set when only exported services are replicated, see
+	// ServiceExported.
+	ExportOptIn    bool
+	ExportSelector labels.Selector
+	// ServiceNaming is one of the SERVICE_NAMING_ modes.
+	ServiceNaming   string
+	ShutdownTimeout time.Duration
+	FlushOnShutdown bool
+	// ReplicationPolicies is set when services are replicated according to
+	// the ReplicationPolicies selecting them, ClusterName is the name of the
+	// local cluster in their destinationClusters.
+	ReplicationPolicies bool
+	ClusterName         string
+	// ServiceReplications is set when the replication of every service is
+	// reported in a ServiceReplication resource.
+	ServiceReplications bool
+
+	// ClusterSecretSelector is set when remote clusters are also discovered
+	// from the Secrets matching it in ClusterSecretNamespace.
+	ClusterSecretSelector  string
+	ClusterSecretNamespace string
+
+	// RemoteClusterNamespace is set when remote clusters are also discovered
+	// from the RemoteCluster resources in this namespace.
+	RemoteClusterNamespace string
+
+	// KubeconfigDir is set when the remote clusters are discovered from the
+	// kubeconfigs in this directory. ClusterPodCIDRs holds the pod CIDRs of
+	// discovered clusters by cluster name.
+	KubeconfigDir   string
+	ClusterPodCIDRs map[string][]*net.IPNet
+	// ClusterNamespaceMappings holds the namespace mappings of discovered
+	// clusters by cluster name.
+	ClusterNamespaceMappings map[string]map[string]string
+
+	// clustersLock guards ClustersToWatch once clusters are added and
+	// removed at runtime.
+	clustersLock sync.RWMutex
+}
+
+// ClusterConfig describes a remote cluster whose objects are replicated. The
+// client is built from Kubeconfig if set, from KubeconfigPath otherwise.
+// Workers and ResyncPeriod override the global settings when set.
+// NamespaceMapping maps the namespaces of the cluster to the local namespaces
+// their objects are replicated to, unmapped namespaces keep their name.
+type ClusterConfig struct {
+	Name             string
+	KubeconfigPath   string
+	Kubeconfig       []byte
+	PodCIDRs         []*net.IPNet
+	Workers          int
+	ResyncPeriod     time.Duration
+	NamespaceMapping map[string]string
+}
+
+// ClusterForIP returns the name of the watched cluster whose pod CIDRs
+// contain ip, if any.
+func (conf *Config) ClusterForIP(ip string) (string, bool) {
+	for _, cluster := range conf.Clusters() {
+		if ContainsIP(cluster.PodCIDRs, ip) {
+			return cluster.Name, true
+		}
+	}
+	return "", false
+}
+
+// LocalNamespace returns the local namespace the objects of namespace in
+// cluster are replicated to. It reports false if namespace is not mapped but
+// another namespace of cluster is mapped onto it, as both would end up in the
+// same local namespace.
+func (conf *Config) LocalNamespace(cluster string, namespace string) (string, bool) {
+	mapping := conf.namespaceMapping(cluster)
+	if local, ok := mapping[namespace]; ok {
+		return local, true
+	}
+	for _, local := range mapping {
+		if local == namespace {
+			return "", false
+		}
+	}
+	return namespace, true
+}
+
+// RemoteNamespace returns the namespace of cluster whose objects are
+// replicated to the local namespace.
+func (conf *Config) RemoteNamespace(cluster string, namespace string) string {
+	for remote, local := range conf.namespaceMapping(cluster) {
+		if local == namespace {
+			return remote
+		}
+	}
+	return namespace
+}
+
+func (conf *Config) namespaceMapping(cluster string) map[string]string {
+	conf.clustersLock.RLock()
+	defer conf.clustersLock.RUnlock()
+	for i := range conf.ClustersToWatch {
+		if conf.ClustersToWatch[i].Name == cluster {
+			return conf.ClustersToWatch[i].NamespaceMapping
+		}
+	}
+	return nil
+}
+
+// Clusters returns the remote clusters currently watched.
+func (conf *Config) Clusters() []ClusterConfig {
+	conf.clustersLock.RLock()
+	defer conf.clustersLock.RUnlock()
+	return append([]ClusterConfig{}, conf.ClustersToWatch...)
+}
+
+// SetCluster adds cluster to the watched clusters, replacing the one of the
+// same name.
+func (conf *Config) SetCluster(cluster ClusterConfig) {
+	conf.clustersLock.Lock()
+	defer conf.clustersLock.Unlock()
+	for i := range conf.ClustersToWatch {
+		if conf.ClustersToWatch[i].Name == cluster.Name {
+			conf.ClustersToWatch[i] = cluster
+			return
+		}
+	}
+	conf.ClustersToWatch = append(conf.ClustersToWatch, cluster)
+}
+
+// RemoveCluster stops considering the cluster named name as watched.
+func (conf *Config) RemoveCluster(name string) {
+	conf.clustersLock.Lock()
+	defer conf.clustersLock.Unlock()
+	for i := range conf.ClustersToWatch {
+		if conf.ClustersToWatch[i].Name == name {
+			conf.ClustersToWatch = append(conf.ClustersToWatch[:i], conf.ClustersToWatch[i+1:]...)
+			return
+		}
+	}
+}
+
+// ServiceExported reports whether a service with svcLabels and annotations is
+// replicated. Unless ExportOptIn is set every service is, otherwise only those
+// labelled or annotated SVC_EXPORT_KEY=true or matching ExportSelector.
+func (conf *Config) ServiceExported(svcLabels map[string]string, annotations map[string]string) bool {
+	if !conf.ExportOptIn {
+		return true
+	}
+	if svcLabels[SVC_EXPORT_KEY] == "true" || annotations[SVC_EXPORT_KEY] == "true" {
+		return true
+	}
+	return conf.ExportSelector != nil && conf.ExportSelector.Matches(labels.Set(svcLabels))
+}
+
+// MergedNames reports whether services are replicated under their own name,
+// merging the endpoints of all clusters.
+func (conf *Config) MergedNames() bool {
+	return conf.ServiceNaming != SERVICE_NAMING_PER_CLUSTER
+}
+
+// PerClusterNames reports whether services are replicated under a name
+// suffixed with their cluster, holding only the endpoints of that cluster.
+func (conf *Config) PerClusterNames() bool {
+	return conf.ServiceNaming == SERVICE_NAMING_PER_CLUSTER || conf.ServiceNaming == SERVICE_NAMING_BOTH
+}
+
+// IsLocalIP reports whether ip belongs to the pod CIDRs of the local cluster.
+func (conf *Config) IsLocalIP(ip string) bool {
+	return ContainsIP(conf.LocalPodCIDRs, ip)
+}
+
+// ContainsIP reports whether any of cidrs contains ip.
+func ContainsIP(cidrs []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, cidr := range cidrs {
+		if cidr.Contains(parsed) {
+			return true
+		}
+	}
+	return false
 }
 
 const REPLICATED_LABEL_KEY = "replicated"
@@ -26,3 +226,20 @@ const SVC_ANNOTATION_UNION = "union"
 const SVC_ANNOTATION_SOURCE = "source"
 const SVC_ANNOTATION_RECEIVER = "receiver"
 const SVC_ANNOTATION_SINGULAR = "singular"
+const SVC_ANNOTATION_FAILOVER = "failover"
+const SVC_ANNOTATION_PHASE_KEY = "vmware.com/syndicate-phase"
+const SVC_EXPORT_KEY = "vmware.com/syndicate-export"
+const EP_ANNOTATION_SOURCES_KEY = "vmware.com/syndicate-sources"
+const EP_ANNOTATION_FAILOVER_KEY = "vmware.com/syndicate-failover"
+const EPS_LABEL_MANAGED_BY_VAL = "endpoints-sync-controller.vmware.com"
+const EPS_LABEL_SOURCE_CLUSTER_KEY = "vmware.com/syndicate-source-cluster"
+const EPS_ANNOTATION_SOURCE_NAME_KEY = "vmware.com/syndicate-source-name"
+const SERVICE_NAMING_MERGED = "merged"
+const SERVICE_NAMING_PER_CLUSTER = "per-cluster"
+const SERVICE_NAMING_BOTH = "both"
+const REPLICA_NAMING_LABEL_KEY = "vmware.com/syndicate-naming"
+const POLICY_NAMING_ANNOTATION_KEY = "vmware.com/syndicate-policy-naming"
+const CLUSTER_SECRET_LABEL_SELECTOR = "vmware.com/syndicate-cluster=true"
+const CLUSTER_SECRET_KUBECONFIG_KEY = "kubeconfig"
+const CLUSTER_SECRET_ANNOTATION_NAME_KEY = "vmware.com/syndicate-cluster-name"
+const CLUSTER_SECRET_ANNOTATION_PODCIDRS_KEY = "vmware.com/syndicate-pod-cidrs"
@@ -0,0 +1,160 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package config
+
+import (
+	"k8s.io/apimachinery/pkg/labels"
+	"net"
+	"testing"
+)
+
+func parseCIDRs(t *testing.T, cidrs ...string) []*net.IPNet {
+	t.Helper()
+	var parsed []*net.IPNet
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("ParseCIDR(%q) error = %v", cidr, err)
+		}
+		parsed = append(parsed, ipNet)
+	}
+	return parsed
+}
+
+func TestClusterForIP(t *testing.T) {
+	conf := &Config{
+		ClustersToWatch: []ClusterConfig{
+			{Name: "cluster-a", PodCIDRs: parseCIDRs(t, "10.1.0.0/16", "fd00:1::/64")},
+			{Name: "cluster-b", PodCIDRs: parseCIDRs(t, "10.10.0.0/16", "fd00:10::/64")},
+		},
+		LocalPodCIDRs: parseCIDRs(t, "10.0.0.0/16"),
+	}
+	tests := []struct {
+		name        string
+		ip          string
+		wantCluster string
+		wantOK      bool
+	}{
+		{name: "match", ip: "10.1.2.3", wantCluster: "cluster-a", wantOK: true},
+		{name: "prefix lookalike", ip: "10.10.2.3", wantCluster: "cluster-b", wantOK: true},
+		{name: "no cidr", ip: "10.2.0.1", wantOK: false},
+		{name: "local address", ip: "10.0.0.1", wantOK: false},
+		{name: "unparsable", ip: "10.1.2", wantOK: false},
+		{name: "empty", ip: "", wantOK: false},
+		{name: "ipv6 match", ip: "fd00:1::5", wantCluster: "cluster-a", wantOK: true},
+		{name: "ipv6 prefix lookalike", ip: "fd00:10::5", wantCluster: "cluster-b", wantOK: true},
+		{name: "ipv6 no cidr", ip: "fd00:2::5", wantOK: false},
+		{name: "ipv4-mapped ipv6", ip: "::ffff:10.1.2.3", wantCluster: "cluster-a", wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, ok := conf.ClusterForIP(tt.ip)
+			if cluster != tt.wantCluster || ok != tt.wantOK {
+				t.Errorf("ClusterForIP(%q) = %q, %v, want %q, %v", tt.ip, cluster, ok, tt.wantCluster, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestContainsIP(t *testing.T) {
+	tests := []struct {
+		name  string
+		cidrs []string
+		ip    string
+		want  bool
+	}{
+		{name: "match", cidrs: []string{"10.1.0.0/16"}, ip: "10.1.255.255", want: true},
+		{name: "second cidr", cidrs: []string{"10.1.0.0/16", "10.3.0.0/16"}, ip: "10.3.0.1", want: true},
+		{name: "outside", cidrs: []string{"10.1.0.0/16"}, ip: "10.2.0.1", want: false},
+		{name: "prefix lookalike", cidrs: []string{"10.1.0.0/16"}, ip: "10.10.0.1", want: false},
+		{name: "no cidrs", ip: "10.1.0.1", want: false},
+		{name: "unparsable", cidrs: []string{"10.1.0.0/16"}, ip: "pod-a", want: false},
+		{name: "ipv6", cidrs: []string{"fd00:1::/64"}, ip: "fd00:1::ab", want: true},
+		{name: "ipv6 non-canonical", cidrs: []string{"fd00:1::/64"}, ip: "FD00:1:0:0::AB", want: true},
+		{name: "ipv6 outside", cidrs: []string{"fd00:1::/64"}, ip: "fd00:1:0:1::ab", want: false},
+		{name: "ipv4-mapped ipv6", cidrs: []string{"10.1.0.0/16"}, ip: "::ffff:10.1.0.1", want: true},
+		{name: "ipv4 in ipv6 cidr", cidrs: []string{"fd00:1::/64"}, ip: "10.1.0.1", want: false},
+		{name: "dual-stack cidrs", cidrs: []string{"10.1.0.0/16", "fd00:1::/64"}, ip: "fd00:1::1", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ContainsIP(parseCIDRs(t, tt.cidrs...), tt.ip); got != tt.want {
+				t.Errorf("ContainsIP(%v, %q) = %v, want %v", tt.cidrs, tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNamespaceMapping(t *testing.T) {
+	conf := &Config{
+		ClustersToWatch: []ClusterConfig{
+			{Name: "cluster-a", NamespaceMapping: map[string]string{"payments": "payments-a", "shop": "retail"}},
+			{Name: "cluster-b"},
+		},
+	}
+	tests := []struct {
+		name       string
+		cluster    string
+		namespace  string
+		wantLocal  string
+		wantOK     bool
+		wantRemote string
+	}{
+		{name: "mapped", cluster: "cluster-a", namespace: "payments", wantLocal: "payments-a", wantOK: true, wantRemote: "payments"},
+		{name: "not mapped", cluster: "cluster-a", namespace: "orders", wantLocal: "orders", wantOK: true, wantRemote: "orders"},
+		{name: "shadowed by a mapping", cluster: "cluster-a", namespace: "retail", wantOK: false, wantRemote: "shop"},
+		{name: "other cluster", cluster: "cluster-b", namespace: "payments", wantLocal: "payments", wantOK: true, wantRemote: "payments"},
+		{name: "unknown cluster", cluster: "cluster-c", namespace: "retail", wantLocal: "retail", wantOK: true, wantRemote: "retail"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local, ok := conf.LocalNamespace(tt.cluster, tt.namespace)
+			if local != tt.wantLocal || ok != tt.wantOK {
+				t.Errorf("LocalNamespace(%q, %q) = %q, %v, want %q, %v", tt.cluster, tt.namespace, local, ok, tt.wantLocal, tt.wantOK)
+			}
+			if remote := conf.RemoteNamespace(tt.cluster, tt.namespace); remote != tt.wantRemote {
+				t.Errorf("RemoteNamespace(%q, %q) = %q, want %q", tt.cluster, tt.namespace, remote, tt.wantRemote)
+			}
+			if !ok {
+				return
+			}
+			if remote := conf.RemoteNamespace(tt.cluster, local); remote != tt.namespace {
+				t.Errorf("RemoteNamespace(%q, LocalNamespace(%q)) = %q, want %q", tt.cluster, tt.namespace, remote, tt.namespace)
+			}
+		})
+	}
+}
+
+func TestServiceExported(t *testing.T) {
+	selector, err := labels.Parse("tier=public")
+	if err != nil {
+		t.Fatalf("labels.Parse() error = %v", err)
+	}
+	tests := []struct {
+		name        string
+		optIn       bool
+		selector    labels.Selector
+		labels      map[string]string
+		annotations map[string]string
+		want        bool
+	}{
+		{name: "opt-in off", want: true},
+		{name: "opt-in off, exported false", labels: map[string]string{SVC_EXPORT_KEY: "false"}, want: true},
+		{name: "not exported", optIn: true, want: false},
+		{name: "label", optIn: true, labels: map[string]string{SVC_EXPORT_KEY: "true"}, want: true},
+		{name: "annotation", optIn: true, annotations: map[string]string{SVC_EXPORT_KEY: "true"}, want: true},
+		{name: "label not true", optIn: true, labels: map[string]string{SVC_EXPORT_KEY: "yes"}, want: false},
+		{name: "selector", optIn: true, selector: selector, labels: map[string]string{"tier": "public"}, want: true},
+		{name: "selector not matching", optIn: true, selector: selector, labels: map[string]string{"tier": "internal"}, want: false},
+		{name: "selector annotation ignored", optIn: true, selector: selector, annotations: map[string]string{"tier": "public"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &Config{ExportOptIn: tt.optIn, ExportSelector: tt.selector}
+			if got := conf.ServiceExported(tt.labels, tt.annotations); got != tt.want {
+				t.Errorf("ServiceExported(%v, %v) = %v, want %v", tt.labels, tt.annotations, got, tt.want)
+			}
+		})
+	}
+}
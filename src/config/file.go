@@ -0,0 +1,445 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package config
+
+import (
+	"fmt"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"net"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"time"
+)
+
+// FILE_API_VERSION is the only version of the config file understood by
+// this release.
+const FILE_API_VERSION = "syndicate.vmware.com/v1"
+
+// DEFAULT_KUBECONFIG_DIR is where kubeconfigs of remote clusters are looked
+// up when the config file does not list any cluster. Clusters are then added
+// and removed as their kubeconfigs appear and disappear, ClusterPodCIDRs
+// holds their pod CIDRs by cluster name.
+const DEFAULT_KUBECONFIG_DIR = "/etc/kubeconfigs"
+
+// File is the on-disk YAML or JSON representation of Config.
+type File struct {
+	APIVersion      string              `json:"apiVersion"`
+	KubeconfigDir   string              `json:"kubeconfigDir"`
+	Clusters        []ClusterFile       `json:"clusters"`
+	ClusterPodCIDRs map[string][]string `json:"clusterPodCIDRs"`
+	// ClusterNamespaceMappings holds the namespace mappings of clusters
+	// discovered from KubeconfigDir, Secrets or RemoteClusters by name.
+	ClusterNamespaceMappings map[string]map[string]string `json:"clusterNamespaceMappings"`
+	ClusterSecrets           ClusterSecretsFile           `json:"clusterSecrets"`
+	RemoteClusters           RemoteClustersFile           `json:"remoteClusters"`
+	ClusterToApply           string                       `json:"clusterToApply"`
+	LocalPodCIDRs            []string                     `json:"localPodCIDRs"`
+	Namespaces               NamespacesFile               `json:"namespaces"`
+	ReplicatedLabelValue     string                       `json:"replicatedLabelValue"`
+	Watch                    WatchFile                    `json:"watch"`
+	ResyncPeriod             meta_v1.Duration             `json:"resyncPeriod"`
+	Workers                  int                          `json:"workers"`
+	MaxRetries               int                          `json:"maxRetries"`
+	RetryBaseDelay           meta_v1.Duration             `json:"retryBaseDelay"`
+	RetryMaxDelay            meta_v1.Duration             `json:"retryMaxDelay"`
+	LeaderElection           LeaderElectFile              `json:"leaderElection"`
+	MetricsAddr              string                       `json:"metricsAddr"`
+	GCPeriod                 meta_v1.Duration             `json:"gcPeriod"`
+	DryRun                   bool                         `json:"dryRun"`
+	ServiceNaming            string                       `json:"serviceNaming"`
+	ServiceExport            ServiceExportFile            `json:"serviceExport"`
+	ShutdownTimeout          meta_v1.Duration             `json:"shutdownTimeout"`
+	FlushOnShutdown          bool                         `json:"flushOnShutdown"`
+	ServiceReplications      bool                         `json:"serviceReplications"`
+	ReplicationPolicies      bool                         `json:"replicationPolicies"`
+	ClusterName              string                       `json:"clusterName"`
+}
+
+// ClusterFile holds the settings of a single remote cluster. KubeconfigPath
+// defaults to the file named after the cluster in KubeconfigDir, Workers and
+// ResyncPeriod default to the global settings.
+type ClusterFile struct {
+	Name             string            `json:"name"`
+	KubeconfigPath   string            `json:"kubeconfigPath"`
+	PodCIDRs         []string          `json:"podCIDRs"`
+	NamespaceMapping map[string]string `json:"namespaceMapping"`
+	Workers          int               `json:"workers"`
+	ResyncPeriod     meta_v1.Duration  `json:"resyncPeriod"`
+}
+
+// ClusterSecretsFile enables discovering remote clusters from the Secrets
+// matching Selector in Namespace, which defaults to the namespace of the
+// controller.
+type ClusterSecretsFile struct {
+	Enabled   bool   `json:"enabled"`
+	Namespace string `json:"namespace"`
+	Selector  string `json:"selector"`
+}
+
+// RemoteClustersFile enables discovering remote clusters from the
+// RemoteCluster resources in Namespace, which defaults to the namespace of the
+// controller. The kubeconfig directory is not watched when enabled.
+type RemoteClustersFile struct {
+	Enabled   bool   `json:"enabled"`
+	Namespace string `json:"namespace"`
+}
+
+// ServiceExportFile makes replication opt-in: with OptIn set only services
+// labelled or annotated vmware.com/syndicate-export=true or matching Selector
+// are replicated.
+type ServiceExportFile struct {
+	OptIn    bool   `json:"optIn"`
+	Selector string `json:"selector"`
+}
+
+// NamespacesFile selects the namespaces to replicate. Exclude holds globs of
+// namespaces that are never replicated, Rules are applied after them in
+// order.
+type NamespacesFile struct {
+	Watch   []string            `json:"watch"`
+	Exclude []string            `json:"exclude"`
+	Rules   []NamespaceRuleFile `json:"rules"`
+}
+
+// NamespaceRuleFile includes or excludes, depending on Action, the namespaces
+// matching exactly one of Glob, Regex and Selector.
+type NamespaceRuleFile struct {
+	Action   string `json:"action"`
+	Glob     string `json:"glob,omitempty"`
+	Regex    string `json:"regex,omitempty"`
+	Selector string `json:"selector,omitempty"`
+}
+
+type WatchFile struct {
+	Namespaces     bool `json:"namespaces"`
+	Endpoints      bool `json:"endpoints"`
+	EndpointSlices bool `json:"endpointSlices"`
+	Services       bool `json:"services"`
+}
+
+type LeaderElectFile struct {
+	Enabled        bool             `json:"enabled"`
+	LeaseName      string           `json:"leaseName"`
+	LeaseNamespace string           `json:"leaseNamespace"`
+	Identity       string           `json:"identity"`
+	LeaseDuration  meta_v1.Duration `json:"leaseDuration"`
+	RenewDeadline  meta_v1.Duration `json:"renewDeadline"`
+	RetryPeriod    meta_v1.Duration `json:"retryPeriod"`
+}
+
+// DefaultFile returns the settings used for everything the config file and
+// the environment leave unset.
+func DefaultFile() *File {
+	return &File{
+		APIVersion:           FILE_API_VERSION,
+		KubeconfigDir:        DEFAULT_KUBECONFIG_DIR,
+		ReplicatedLabelValue: "true",
+		ClusterSecrets: ClusterSecretsFile{
+			Selector: CLUSTER_SECRET_LABEL_SELECTOR,
+		},
+		Watch: WatchFile{
+			Namespaces: true,
+			Endpoints:  true,
+			Services:   true,
+		},
+		ResyncPeriod:   meta_v1.Duration{Duration: 5 * time.Minute},
+		Workers:        2,
+		MaxRetries:     10,
+		RetryBaseDelay: meta_v1.Duration{Duration: 500 * time.Millisecond},
+		RetryMaxDelay:  meta_v1.Duration{Duration: 5 * time.Minute},
+		LeaderElection: LeaderElectFile{
+			LeaseName:     "k8s-endpoints-sync-controller",
+			LeaseDuration: meta_v1.Duration{Duration: 15 * time.Second},
+			RenewDeadline: meta_v1.Duration{Duration: 10 * time.Second},
+			RetryPeriod:   meta_v1.Duration{Duration: 2 * time.Second},
+		},
+		MetricsAddr:     ":8080",
+		GCPeriod:        meta_v1.Duration{Duration: 10 * time.Minute},
+		ServiceNaming:   SERVICE_NAMING_MERGED,
+		ShutdownTimeout: meta_v1.Duration{Duration: 30 * time.Second},
+	}
+}
+
+// ParseFile decodes data over the defaults. Unknown fields are rejected and
+// apiVersion must be set.
+func ParseFile(data []byte) (*File, error) {
+	file := DefaultFile()
+	file.APIVersion = ""
+	if err := yaml.UnmarshalStrict(data, file); err != nil {
+		return nil, err
+	}
+	if file.APIVersion == "" {
+		return nil, fmt.Errorf("apiVersion must be set to %s", FILE_API_VERSION)
+	}
+	return file, nil
+}
+
+// Validate reports every invalid setting of file.
+func (file *File) Validate() error {
+	var errs field.ErrorList
+	if file.APIVersion != FILE_API_VERSION {
+		errs = append(errs, field.NotSupported(field.NewPath("apiVersion"), file.APIVersion, []string{FILE_API_VERSION}))
+	}
+
+	clustersPath := field.NewPath("clusters")
+	names := map[string]bool{}
+	for i, cluster := range file.Clusters {
+		path := clustersPath.Index(i)
+		if cluster.Name == "" {
+			errs = append(errs, field.Required(path.Child("name"), ""))
+		} else if names[cluster.Name] {
+			errs = append(errs, field.Duplicate(path.Child("name"), cluster.Name))
+		}
+		names[cluster.Name] = true
+		if cluster.KubeconfigPath == "" && file.KubeconfigDir == "" {
+			errs = append(errs, field.Required(path.Child("kubeconfigPath"), "kubeconfigDir is not set"))
+		}
+		errs = append(errs, validateCIDRs(path.Child("podCIDRs"), cluster.PodCIDRs)...)
+		errs = append(errs, ValidateNamespaceMapping(path.Child("namespaceMapping"), cluster.NamespaceMapping)...)
+		if cluster.Workers < 0 {
+			errs = append(errs, field.Invalid(path.Child("workers"), cluster.Workers, "must not be negative"))
+		}
+		if cluster.ResyncPeriod.Duration < 0 {
+			errs = append(errs, field.Invalid(path.Child("resyncPeriod"), cluster.ResyncPeriod.Duration.String(), "must not be negative"))
+		}
+	}
+	if len(file.Clusters) == 0 && file.KubeconfigDir == "" && !file.ClusterSecrets.Enabled && !file.RemoteClusters.Enabled {
+		errs = append(errs, field.Required(field.NewPath("kubeconfigDir"), "no clusters are listed"))
+	}
+	for name, cidrs := range file.ClusterPodCIDRs {
+		errs = append(errs, validateCIDRs(field.NewPath("clusterPodCIDRs").Key(name), cidrs)...)
+	}
+	for name, mapping := range file.ClusterNamespaceMappings {
+		errs = append(errs, ValidateNamespaceMapping(field.NewPath("clusterNamespaceMappings").Key(name), mapping)...)
+	}
+	if file.ClusterSecrets.Enabled {
+		secretsPath := field.NewPath("clusterSecrets")
+		if file.ClusterSecrets.Namespace == "" {
+			errs = append(errs, field.Required(secretsPath.Child("namespace"), ""))
+		}
+		if _, err := labels.Parse(file.ClusterSecrets.Selector); err != nil || file.ClusterSecrets.Selector == "" {
+			errs = append(errs, field.Invalid(secretsPath.Child("selector"), file.ClusterSecrets.Selector, "must be a non-empty label selector"))
+		}
+	}
+	if file.RemoteClusters.Enabled && file.RemoteClusters.Namespace == "" {
+		errs = append(errs, field.Required(field.NewPath("remoteClusters", "namespace"), ""))
+	}
+	if file.ClusterToApply != "" && len(file.Clusters) > 0 && !names[file.ClusterToApply] {
+		errs = append(errs, field.NotFound(field.NewPath("clusterToApply"), file.ClusterToApply))
+	}
+	errs = append(errs, validateCIDRs(field.NewPath("localPodCIDRs"), file.LocalPodCIDRs)...)
+
+	for i, ns := range file.Namespaces.Watch {
+		for _, msg := range validation.IsDNS1123Label(ns) {
+			errs = append(errs, field.Invalid(field.NewPath("namespaces", "watch").Index(i), ns, msg))
+		}
+	}
+	for i, ns := range file.Namespaces.Exclude {
+		if _, err := NewNamespaceRule(NAMESPACE_RULE_EXCLUDE, ns, "", ""); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("namespaces", "exclude").Index(i), ns, err.Error()))
+		}
+	}
+	for i, rule := range file.Namespaces.Rules {
+		if _, err := NewNamespaceRule(rule.Action, rule.Glob, rule.Regex, rule.Selector); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("namespaces", "rules").Index(i), rule, err.Error()))
+		}
+	}
+	if file.ReplicatedLabelValue == "" {
+		errs = append(errs, field.Required(field.NewPath("replicatedLabelValue"), ""))
+	}
+	for _, msg := range validation.IsValidLabelValue(file.ReplicatedLabelValue) {
+		errs = append(errs, field.Invalid(field.NewPath("replicatedLabelValue"), file.ReplicatedLabelValue, msg))
+	}
+
+	watchPath := field.NewPath("watch")
+	if file.Watch.Endpoints && file.Watch.EndpointSlices {
+		errs = append(errs, field.Forbidden(watchPath.Child("endpointSlices"), "endpoints and endpointSlices are mutually exclusive"))
+	}
+	if !file.Watch.Namespaces && !file.Watch.Endpoints && !file.Watch.EndpointSlices && !file.Watch.Services {
+		errs = append(errs, field.Required(watchPath, "at least one kind must be watched"))
+	}
+
+	errs = append(errs, validatePositive(field.NewPath("resyncPeriod"), file.ResyncPeriod)...)
+	if file.Workers < 1 {
+		errs = append(errs, field.Invalid(field.NewPath("workers"), file.Workers, "must be at least 1"))
+	}
+	if file.MaxRetries < 0 {
+		errs = append(errs, field.Invalid(field.NewPath("maxRetries"), file.MaxRetries, "must not be negative"))
+	}
+	errs = append(errs, validatePositive(field.NewPath("retryBaseDelay"), file.RetryBaseDelay)...)
+	errs = append(errs, validatePositive(field.NewPath("retryMaxDelay"), file.RetryMaxDelay)...)
+	if file.RetryMaxDelay.Duration < file.RetryBaseDelay.Duration {
+		errs = append(errs, field.Invalid(field.NewPath("retryMaxDelay"), file.RetryMaxDelay.Duration.String(), "must not be less than retryBaseDelay"))
+	}
+
+	leaderPath := field.NewPath("leaderElection")
+	if file.LeaderElection.Enabled {
+		if file.LeaderElection.LeaseName == "" {
+			errs = append(errs, field.Required(leaderPath.Child("leaseName"), ""))
+		}
+		if file.LeaderElection.LeaseNamespace == "" {
+			errs = append(errs, field.Required(leaderPath.Child("leaseNamespace"), ""))
+		}
+		if file.LeaderElection.Identity == "" {
+			errs = append(errs, field.Required(leaderPath.Child("identity"), ""))
+		}
+		errs = append(errs, validatePositive(leaderPath.Child("retryPeriod"), file.LeaderElection.RetryPeriod)...)
+		if file.LeaderElection.RenewDeadline.Duration <= file.LeaderElection.RetryPeriod.Duration {
+			errs = append(errs, field.Invalid(leaderPath.Child("renewDeadline"), file.LeaderElection.RenewDeadline.Duration.String(), "must be greater than retryPeriod"))
+		}
+		if file.LeaderElection.LeaseDuration.Duration <= file.LeaderElection.RenewDeadline.Duration {
+			errs = append(errs, field.Invalid(leaderPath.Child("leaseDuration"), file.LeaderElection.LeaseDuration.Duration.String(), "must be greater than renewDeadline"))
+		}
+	}
+
+	if file.MetricsAddr == "" {
+		errs = append(errs, field.Required(field.NewPath("metricsAddr"), ""))
+	}
+	errs = append(errs, validatePositive(field.NewPath("gcPeriod"), file.GCPeriod)...)
+	if _, err := labels.Parse(file.ServiceExport.Selector); err != nil {
+		errs = append(errs, field.Invalid(field.NewPath("serviceExport", "selector"), file.ServiceExport.Selector, err.Error()))
+	}
+	switch file.ServiceNaming {
+	case SERVICE_NAMING_MERGED, SERVICE_NAMING_PER_CLUSTER, SERVICE_NAMING_BOTH:
+	default:
+		errs = append(errs, field.NotSupported(field.NewPath("serviceNaming"), file.ServiceNaming,
+			[]string{SERVICE_NAMING_MERGED, SERVICE_NAMING_PER_CLUSTER, SERVICE_NAMING_BOTH}))
+	}
+	errs = append(errs, validatePositive(field.NewPath("shutdownTimeout"), file.ShutdownTimeout)...)
+	if file.ReplicationPolicies && file.ClusterName == "" {
+		errs = append(errs, field.Required(field.NewPath("clusterName"), "replicationPolicies is enabled"))
+	}
+	return errs.ToAggregate()
+}
+
+// ValidateNamespaceMapping checks that mapping maps namespace names to
+// distinct namespace names.
+func ValidateNamespaceMapping(path *field.Path, mapping map[string]string) field.ErrorList {
+	var errs field.ErrorList
+	targets := map[string]string{}
+	for remote, local := range mapping {
+		for _, msg := range validation.IsDNS1123Label(remote) {
+			errs = append(errs, field.Invalid(path.Key(remote), remote, msg))
+		}
+		for _, msg := range validation.IsDNS1123Label(local) {
+			errs = append(errs, field.Invalid(path.Key(remote), local, msg))
+		}
+		if other, ok := targets[local]; ok {
+			errs = append(errs, field.Duplicate(path.Key(remote), local+" is also the target of "+other))
+		}
+		targets[local] = remote
+	}
+	return errs
+}
+
+func validateCIDRs(path *field.Path, cidrs []string) field.ErrorList {
+	var errs field.ErrorList
+	for i, cidr := range cidrs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			errs = append(errs, field.Invalid(path.Index(i), cidr, err.Error()))
+		}
+	}
+	return errs
+}
+
+func validatePositive(path *field.Path, d meta_v1.Duration) field.ErrorList {
+	if d.Duration <= 0 {
+		return field.ErrorList{field.Invalid(path, d.Duration.String(), "must be greater than 0")}
+	}
+	return nil
+}
+
+// Config validates file and converts it to a Config.
+func (file *File) Config() (*Config, error) {
+	if err := file.Validate(); err != nil {
+		return nil, err
+	}
+	conf := &Config{
+		ClusterToApply:      file.ClusterToApply,
+		LocalPodCIDRs:       mustParseCIDRs(file.LocalPodCIDRs),
+		NamespacesToWatch:   file.Namespaces.Watch,
+		ReplicatedLabelVal:  file.ReplicatedLabelValue,
+		WatchNamespaces:     file.Watch.Namespaces,
+		WatchEndpoints:      file.Watch.Endpoints,
+		WatchEndpointSlices: file.Watch.EndpointSlices,
+		WatchServices:       file.Watch.Services,
+		ResyncPeriod:        file.ResyncPeriod.Duration,
+		Workers:             file.Workers,
+		MaxRetries:          file.MaxRetries,
+		RetryBaseDelay:      file.RetryBaseDelay.Duration,
+		RetryMaxDelay:       file.RetryMaxDelay.Duration,
+		LeaderElection:      file.LeaderElection.Enabled,
+		LeaseName:           file.LeaderElection.LeaseName,
+		LeaseNamespace:      file.LeaderElection.LeaseNamespace,
+		LeaseIdentity:       file.LeaderElection.Identity,
+		LeaseDuration:       file.LeaderElection.LeaseDuration.Duration,
+		RenewDeadline:       file.LeaderElection.RenewDeadline.Duration,
+		RetryPeriod:         file.LeaderElection.RetryPeriod.Duration,
+		MetricsAddr:         file.MetricsAddr,
+		GCPeriod:            file.GCPeriod.Duration,
+		DryRun:              file.DryRun,
+		ServiceNaming:       file.ServiceNaming,
+		ExportOptIn:         file.ServiceExport.OptIn,
+		ShutdownTimeout:     file.ShutdownTimeout.Duration,
+		FlushOnShutdown:     file.FlushOnShutdown,
+		ServiceReplications: file.ServiceReplications,
+		ReplicationPolicies: file.ReplicationPolicies,
+		ClusterName:         file.ClusterName,
+	}
+	if file.ClusterSecrets.Enabled {
+		conf.ClusterSecretSelector = file.ClusterSecrets.Selector
+		conf.ClusterSecretNamespace = file.ClusterSecrets.Namespace
+	}
+	if file.RemoteClusters.Enabled {
+		conf.RemoteClusterNamespace = file.RemoteClusters.Namespace
+	}
+	if len(file.Clusters) == 0 && !file.RemoteClusters.Enabled {
+		conf.KubeconfigDir = file.KubeconfigDir
+	}
+	for _, ns := range file.Namespaces.Exclude {
+		rule, _ := NewNamespaceRule(NAMESPACE_RULE_EXCLUDE, ns, "", "")
+		conf.NamespaceRules = append(conf.NamespaceRules, rule)
+	}
+	for _, rule := range file.Namespaces.Rules {
+		namespaceRule, _ := NewNamespaceRule(rule.Action, rule.Glob, rule.Regex, rule.Selector)
+		conf.NamespaceRules = append(conf.NamespaceRules, namespaceRule)
+	}
+	conf.ClusterPodCIDRs = map[string][]*net.IPNet{}
+	for name, cidrs := range file.ClusterPodCIDRs {
+		conf.ClusterPodCIDRs[name] = mustParseCIDRs(cidrs)
+	}
+	conf.ClusterNamespaceMappings = file.ClusterNamespaceMappings
+	if file.ServiceExport.Selector != "" {
+		conf.ExportSelector, _ = labels.Parse(file.ServiceExport.Selector)
+	}
+	for _, cluster := range file.Clusters {
+		kubeconfigPath := cluster.KubeconfigPath
+		if kubeconfigPath == "" {
+			kubeconfigPath = strings.TrimSuffix(file.KubeconfigDir, "/") + "/" + cluster.Name
+		}
+		conf.ClustersToWatch = append(conf.ClustersToWatch, ClusterConfig{
+			Name:             cluster.Name,
+			KubeconfigPath:   kubeconfigPath,
+			PodCIDRs:         mustParseCIDRs(cluster.PodCIDRs),
+			NamespaceMapping: cluster.NamespaceMapping,
+			Workers:          cluster.Workers,
+			ResyncPeriod:     cluster.ResyncPeriod.Duration,
+		})
+	}
+	return conf, nil
+}
+
+// mustParseCIDRs parses cidrs that have already been validated.
+func mustParseCIDRs(cidrs []string) []*net.IPNet {
+	var ipnets []*net.IPNet
+	for _, cidr := range cidrs {
+		if _, ipnet, err := net.ParseCIDR(cidr); err == nil {
+			ipnets = append(ipnets, ipnet)
+		}
+	}
+	return ipnets
+}
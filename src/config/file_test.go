@@ -0,0 +1,215 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package config
+
+import (
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+		check   func(*File) bool
+	}{
+		{
+			name: "defaults kept",
+			data: "apiVersion: syndicate.vmware.com/v1\n",
+			check: func(file *File) bool {
+				return file.Workers == 2 && file.ResyncPeriod.Duration == 5*time.Minute && file.Watch.Endpoints
+			},
+		},
+		{
+			name: "defaults overridden",
+			data: "apiVersion: syndicate.vmware.com/v1\nworkers: 4\nresyncPeriod: 1m\nwatch:\n  endpoints: false\n  endpointSlices: true\n",
+			check: func(file *File) bool {
+				return file.Workers == 4 && file.ResyncPeriod.Duration == time.Minute && !file.Watch.Endpoints && file.Watch.EndpointSlices
+			},
+		},
+		{
+			name:    "apiVersion missing",
+			data:    "workers: 4\n",
+			wantErr: "apiVersion must be set",
+		},
+		{
+			name:    "unknown field",
+			data:    "apiVersion: syndicate.vmware.com/v1\nworker: 4\n",
+			wantErr: "unknown field",
+		},
+		{
+			name:    "invalid duration",
+			data:    "apiVersion: syndicate.vmware.com/v1\nresyncPeriod: soon\n",
+			wantErr: "invalid duration",
+		},
+		{
+			name: "cluster overrides",
+			data: "apiVersion: syndicate.vmware.com/v1\nclusters:\n- name: a\n  workers: 4\n  resyncPeriod: 1m\n",
+			check: func(file *File) bool {
+				return file.Clusters[0].Workers == 4 && file.Clusters[0].ResyncPeriod.Duration == time.Minute
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := ParseFile([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseFile() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFile() error = %v", err)
+			}
+			if !tt.check(file) {
+				t.Errorf("ParseFile() = %+v", file)
+			}
+		})
+	}
+}
+
+func TestFileValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*File)
+		wantErr string
+	}{
+		{
+			name:   "defaults",
+			modify: func(file *File) {},
+		},
+		{
+			name:    "apiVersion",
+			modify:  func(file *File) { file.APIVersion = "syndicate.vmware.com/v2" },
+			wantErr: "apiVersion",
+		},
+		{
+			name: "duplicate cluster",
+			modify: func(file *File) {
+				file.Clusters = []ClusterFile{{Name: "a"}, {Name: "a"}}
+			},
+			wantErr: "clusters[1].name",
+		},
+		{
+			name:    "no clusters",
+			modify:  func(file *File) { file.KubeconfigDir = "" },
+			wantErr: "kubeconfigDir",
+		},
+		{
+			name: "clusters from secrets",
+			modify: func(file *File) {
+				file.KubeconfigDir = ""
+				file.ClusterSecrets = ClusterSecretsFile{Enabled: true, Namespace: "syndicate", Selector: "kubeconfig"}
+			},
+		},
+		{
+			name: "negative cluster workers",
+			modify: func(file *File) {
+				file.Clusters = []ClusterFile{{Name: "a"}, {Name: "b", Workers: -1}}
+			},
+			wantErr: "clusters[1].workers",
+		},
+		{
+			name: "negative cluster resync period",
+			modify: func(file *File) {
+				file.Clusters = []ClusterFile{{Name: "a", ResyncPeriod: meta_v1.Duration{Duration: -time.Minute}}}
+			},
+			wantErr: "clusters[0].resyncPeriod",
+		},
+		{
+			name: "invalid pod CIDR",
+			modify: func(file *File) {
+				file.ClusterPodCIDRs = map[string][]string{"a": {"10.0.0.0/33"}}
+			},
+			wantErr: "clusterPodCIDRs[a]",
+		},
+		{
+			name: "mapped twice",
+			modify: func(file *File) {
+				file.ClusterNamespaceMappings = map[string]map[string]string{"a": {"x": "z", "y": "z"}}
+			},
+			wantErr: "clusterNamespaceMappings[a]",
+		},
+		{
+			name: "invalid namespace rule",
+			modify: func(file *File) {
+				file.Namespaces.Rules = []NamespaceRuleFile{{Action: NAMESPACE_RULE_INCLUDE, Glob: "a-*", Regex: "a-.*"}}
+			},
+			wantErr: "namespaces.rules[0]",
+		},
+		{
+			name: "endpoints and endpointslices",
+			modify: func(file *File) {
+				file.Watch.EndpointSlices = true
+			},
+			wantErr: "watch.endpointSlices",
+		},
+		{
+			name: "nothing watched",
+			modify: func(file *File) {
+				file.Watch = WatchFile{}
+			},
+			wantErr: "watch",
+		},
+		{
+			name:    "no workers",
+			modify:  func(file *File) { file.Workers = 0 },
+			wantErr: "workers",
+		},
+		{
+			name: "retry delays",
+			modify: func(file *File) {
+				file.RetryMaxDelay.Duration = time.Millisecond
+			},
+			wantErr: "retryMaxDelay",
+		},
+		{
+			name: "lease durations",
+			modify: func(file *File) {
+				file.LeaderElection.Enabled = true
+				file.LeaderElection.LeaseNamespace = "syndicate"
+				file.LeaderElection.Identity = "pod-a"
+				file.LeaderElection.LeaseDuration.Duration = 5 * time.Second
+			},
+			wantErr: "leaderElection.leaseDuration",
+		},
+		{
+			name:    "service naming",
+			modify:  func(file *File) { file.ServiceNaming = "cluster" },
+			wantErr: "serviceNaming",
+		},
+		{
+			name:    "policies without cluster name",
+			modify:  func(file *File) { file.ReplicationPolicies = true },
+			wantErr: "clusterName",
+		},
+		{
+			name: "policies with cluster name",
+			modify: func(file *File) {
+				file.ReplicationPolicies = true
+				file.ClusterName = "cluster-a"
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := DefaultFile()
+			tt.modify(file)
+			err := file.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
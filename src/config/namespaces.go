@@ -0,0 +1,98 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package config
+
+import (
+	"fmt"
+	"k8s.io/apimachinery/pkg/labels"
+	"path"
+	"regexp"
+)
+
+const (
+	NAMESPACE_RULE_INCLUDE = "include"
+	NAMESPACE_RULE_EXCLUDE = "exclude"
+)
+
+// NamespaceRule includes or excludes the namespaces matching Glob, Regex or
+// Selector, exactly one of which is set. Globs and regexes match the whole
+// name of the namespace.
+type NamespaceRule struct {
+	Exclude  bool
+	Glob     string
+	Regex    *regexp.Regexp
+	Selector labels.Selector
+}
+
+// NewNamespaceRule builds the rule of action matching namespaces by the
+// glob, regex or label selector given.
+func NewNamespaceRule(action string, glob string, regex string, selector string) (NamespaceRule, error) {
+	rule := NamespaceRule{}
+	switch action {
+	case NAMESPACE_RULE_INCLUDE:
+	case NAMESPACE_RULE_EXCLUDE:
+		rule.Exclude = true
+	default:
+		return rule, fmt.Errorf("action must be %s or %s", NAMESPACE_RULE_INCLUDE, NAMESPACE_RULE_EXCLUDE)
+	}
+	set := 0
+	if glob != "" {
+		if _, err := path.Match(glob, ""); err != nil {
+			return rule, fmt.Errorf("invalid glob %q: %v", glob, err)
+		}
+		rule.Glob = glob
+		set++
+	}
+	if regex != "" {
+		re, err := regexp.Compile("^(?:" + regex + ")$")
+		if err != nil {
+			return rule, fmt.Errorf("invalid regex %q: %v", regex, err)
+		}
+		rule.Regex = re
+		set++
+	}
+	if selector != "" {
+		sel, err := labels.Parse(selector)
+		if err != nil {
+			return rule, fmt.Errorf("invalid selector %q: %v", selector, err)
+		}
+		rule.Selector = sel
+		set++
+	}
+	if set != 1 {
+		return rule, fmt.Errorf("exactly one of glob, regex and selector must be set")
+	}
+	return rule, nil
+}
+
+// Matches reports whether the namespace named name with nsLabels matches the
+// rule.
+func (rule NamespaceRule) Matches(name string, nsLabels map[string]string) bool {
+	switch {
+	case rule.Glob != "":
+		matched, _ := path.Match(rule.Glob, name)
+		return matched
+	case rule.Regex != nil:
+		return rule.Regex.MatchString(name)
+	case rule.Selector != nil:
+		return rule.Selector.Matches(labels.Set(nsLabels))
+	}
+	return false
+}
+
+// NamespaceIncluded applies NamespaceRules in order to the namespace named
+// name with nsLabels, the first matching rule decides. Namespaces matching no
+// rule are included unless there is an include rule.
+func (conf *Config) NamespaceIncluded(name string, nsLabels map[string]string) bool {
+	included := true
+	for _, rule := range conf.NamespaceRules {
+		if rule.Matches(name, nsLabels) {
+			return !rule.Exclude
+		}
+		if !rule.Exclude {
+			included = false
+		}
+	}
+	return included
+}
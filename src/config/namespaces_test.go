@@ -0,0 +1,92 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package config
+
+import (
+	"testing"
+)
+
+func mustNamespaceRule(t *testing.T, action string, glob string, regex string, selector string) NamespaceRule {
+	t.Helper()
+	rule, err := NewNamespaceRule(action, glob, regex, selector)
+	if err != nil {
+		t.Fatalf("NewNamespaceRule() error = %v", err)
+	}
+	return rule
+}
+
+func TestNewNamespaceRule(t *testing.T) {
+	tests := []struct {
+		name     string
+		action   string
+		glob     string
+		regex    string
+		selector string
+		wantErr  bool
+	}{
+		{name: "glob", action: NAMESPACE_RULE_INCLUDE, glob: "team-*"},
+		{name: "regex", action: NAMESPACE_RULE_EXCLUDE, regex: "team-.*-dev"},
+		{name: "selector", action: NAMESPACE_RULE_INCLUDE, selector: "syndicate=enabled"},
+		{name: "unknown action", action: "skip", glob: "team-*", wantErr: true},
+		{name: "nothing set", action: NAMESPACE_RULE_INCLUDE, wantErr: true},
+		{name: "two set", action: NAMESPACE_RULE_INCLUDE, glob: "team-*", regex: "team-.*", wantErr: true},
+		{name: "invalid glob", action: NAMESPACE_RULE_INCLUDE, glob: "team-[", wantErr: true},
+		{name: "invalid regex", action: NAMESPACE_RULE_INCLUDE, regex: "team-(", wantErr: true},
+		{name: "invalid selector", action: NAMESPACE_RULE_INCLUDE, selector: "syndicate in (", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewNamespaceRule(tt.action, tt.glob, tt.regex, tt.selector)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewNamespaceRule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNamespaceIncluded(t *testing.T) {
+	excludeDev := mustNamespaceRule(t, NAMESPACE_RULE_EXCLUDE, "team-*-dev", "", "")
+	includeTeams := mustNamespaceRule(t, NAMESPACE_RULE_INCLUDE, "", "team-.*", "")
+	includeEnabled := mustNamespaceRule(t, NAMESPACE_RULE_INCLUDE, "", "", "syndicate=enabled")
+	excludeKube := mustNamespaceRule(t, NAMESPACE_RULE_EXCLUDE, "kube-*", "", "")
+
+	tests := []struct {
+		name      string
+		rules     []NamespaceRule
+		namespace string
+		labels    map[string]string
+		want      bool
+	}{
+		{name: "no rules", namespace: "default", want: true},
+		{name: "excluded only", rules: []NamespaceRule{excludeKube}, namespace: "kube-system", want: false},
+		{name: "not excluded", rules: []NamespaceRule{excludeKube}, namespace: "default", want: true},
+		{name: "included by regex", rules: []NamespaceRule{includeTeams}, namespace: "team-a", want: true},
+		{name: "regex matches whole name", rules: []NamespaceRule{includeTeams}, namespace: "my-team-a", want: false},
+		{name: "no include matching", rules: []NamespaceRule{excludeKube, includeTeams}, namespace: "default", want: false},
+		{name: "first rule decides", rules: []NamespaceRule{excludeDev, includeTeams}, namespace: "team-a-dev", want: false},
+		{name: "later include", rules: []NamespaceRule{excludeDev, includeTeams}, namespace: "team-a-prod", want: true},
+		{
+			name:      "included by selector",
+			rules:     []NamespaceRule{includeTeams, includeEnabled},
+			namespace: "payments",
+			labels:    map[string]string{"syndicate": "enabled"},
+			want:      true,
+		},
+		{
+			name:      "selector not matching",
+			rules:     []NamespaceRule{includeEnabled},
+			namespace: "payments",
+			labels:    map[string]string{"syndicate": "disabled"},
+			want:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &Config{NamespaceRules: tt.rules}
+			if got := conf.NamespaceIncluded(tt.namespace, tt.labels); got != tt.want {
+				t.Errorf("NamespaceIncluded(%q) = %v, want %v", tt.namespace, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package controller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	c "github.com/vmware/k8s-endpoints-sync-controller/src/config"
+	"github.com/vmware/k8s-endpoints-sync-controller/src/handlers"
+	"github.com/vmware/k8s-endpoints-sync-controller/src/health"
+	log "github.com/vmware/k8s-endpoints-sync-controller/src/log"
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// kubeconfigPollPeriod is the interval at which the kubeconfig directory is
+// checked for added, removed and changed clusters.
+const kubeconfigPollPeriod = 10 * time.Second
+
+// ClusterManager starts and stops the controllers of remote clusters at
+// runtime.
+type ClusterManager struct {
+	eventHandler handlers.Handler
+	config       *c.Config
+	leading      <-chan struct{}
+
+	lock     sync.Mutex
+	clusters map[string]*runningCluster
+	// errors holds why a cluster could not be started from its source.
+	errors map[string]string
+}
+
+// runningCluster is a started cluster, source tells where its credentials
+// come from and checksum identifies their current version.
+type runningCluster struct {
+	source   string
+	checksum []byte
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	paused   bool
+}
+
+func (running *runningCluster) stop() {
+	running.stopOnce.Do(func() { close(running.stopCh) })
+}
+
+// kubeconfigDirSource is the source of clusters found in the kubeconfig
+// directory, clusters from Secrets have the namespace/name of their Secret.
+const kubeconfigDirSource = "dir"
+
+func NewClusterManager(eventHandler handlers.Handler, config *c.Config, leading <-chan struct{}) *ClusterManager {
+	return &ClusterManager{
+		eventHandler: eventHandler,
+		config:       config,
+		leading:      leading,
+		clusters:     map[string]*runningCluster{},
+		errors:       map[string]string{},
+	}
+}
+
+// Start starts the controller of cluster, stopping the running controller of
+// the same name first.
+func (m *ClusterManager) Start(cluster c.ClusterConfig) {
+	m.start(cluster, "", nil)
+}
+
+func (m *ClusterManager) start(cluster c.ClusterConfig, source string, checksum []byte) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if running, ok := m.clusters[cluster.Name]; ok {
+		if running.source != source {
+			log.Errorf("Ignoring cluster %s from %s, it is already configured from %s", cluster.Name, source, running.source)
+			return
+		}
+		running.stop()
+		unregisterController(cluster.Name)
+	}
+	running := &runningCluster{source: source, checksum: checksum, stopCh: make(chan struct{})}
+	m.clusters[cluster.Name] = running
+	m.config.SetCluster(cluster)
+	health.Register(cluster.Name)
+	go func() {
+		if err := StartController(cluster, m.eventHandler, m.config, m.leading, running.stopCh); err != nil {
+			log.Errorf("failed to start controller for cluster %s %v", cluster.Name, err)
+		}
+	}()
+}
+
+// pause stops the controller of cluster but keeps it configured, so that
+// neither its replicated objects are removed nor garbage is collected until
+// it is resumed by start.
+func (m *ClusterManager) pause(cluster c.ClusterConfig, source string, checksum []byte) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if running, ok := m.clusters[cluster.Name]; ok {
+		if running.source != source {
+			log.Errorf("Ignoring cluster %s from %s, it is already configured from %s", cluster.Name, source, running.source)
+			return
+		}
+		running.stop()
+		unregisterController(cluster.Name)
+	}
+	log.Infof("Pausing cluster %s", cluster.Name)
+	running := &runningCluster{source: source, checksum: checksum, stopCh: make(chan struct{}), paused: true}
+	running.stop()
+	m.clusters[cluster.Name] = running
+	m.config.SetCluster(cluster)
+	health.Remove(cluster.Name)
+}
+
+// Stop stops the controller of the cluster named name and, on the leader,
+// removes the objects replicated from it.
+func (m *ClusterManager) Stop(name string) {
+	m.lock.Lock()
+	running, ok := m.clusters[name]
+	if !ok {
+		m.lock.Unlock()
+		return
+	}
+	ctrl := registeredControllers()[name]
+	delete(m.clusters, name)
+	running.stop()
+	unregisterController(name)
+	m.config.RemoveCluster(name)
+	health.Remove(name)
+	m.lock.Unlock()
+
+	// Let in-progress reconciles finish so they do not bring back what is
+	// removed below.
+	if ctrl != nil && !waitTimeout(&ctrl.workers, m.config.ShutdownTimeout) {
+		log.Errorf("Workers of cluster %s did not stop within %v", name, m.config.ShutdownTimeout)
+	}
+	select {
+	case <-m.leading:
+	default:
+		return
+	}
+	log.Infof("Removing objects replicated from cluster %s", name)
+	if err := m.eventHandler.RemoveCluster(name); err != nil {
+		log.Errorf("Error removing objects of cluster %s %v", name, err)
+	}
+	TriggerGarbageCollection()
+}
+
+// Shutdown stops the controllers of all clusters without removing what was
+// replicated from them. The returned channel is closed once every worker has
+// finished its current reconcile, or its whole queue if FlushOnShutdown is
+// set.
+func (m *ClusterManager) Shutdown() <-chan struct{} {
+	if m.config.FlushOnShutdown {
+		atomic.StoreInt32(&flushQueues, 1)
+	}
+	m.lock.Lock()
+	controllers := registeredControllers()
+	for name, running := range m.clusters {
+		running.stop()
+		delete(m.clusters, name)
+	}
+	m.lock.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		for _, ctrl := range controllers {
+			ctrl.workers.Wait()
+		}
+		close(drained)
+	}()
+	return drained
+}
+
+// waitTimeout waits for wg for at most timeout and reports whether it is
+// done.
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// WatchKubeconfigDir keeps a controller running for every kubeconfig in dir.
+// Clusters are started when their kubeconfig appears, restarted when it
+// changes and stopped when it disappears. The directory is polled so that the
+// atomic ..data symlink swaps of Secret and ConfigMap volumes are picked up.
+// It returns once stopCh is closed.
+func (m *ClusterManager) WatchKubeconfigDir(dir string, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		kubeconfigs, err := readKubeconfigDir(dir)
+		if err != nil {
+			log.Errorf("Error reading dir %v", err)
+			return
+		}
+		m.lock.Lock()
+		var added []string
+		var removed []string
+		for name, checksum := range kubeconfigs {
+			if running, ok := m.clusters[name]; !ok || (running.source == kubeconfigDirSource && !bytes.Equal(running.checksum, checksum)) {
+				added = append(added, name)
+			}
+		}
+		for name, running := range m.clusters {
+			if _, ok := kubeconfigs[name]; !ok && running.source == kubeconfigDirSource {
+				removed = append(removed, name)
+			}
+		}
+		m.lock.Unlock()
+
+		for _, name := range removed {
+			log.Infof("Kubeconfig of cluster %s removed", name)
+			m.Stop(name)
+		}
+		for _, name := range added {
+			log.Infof("Kubeconfig of cluster to watch %s", name)
+			m.start(c.ClusterConfig{
+				Name:             name,
+				KubeconfigPath:   filepath.Join(dir, name),
+				PodCIDRs:         m.config.ClusterPodCIDRs[name],
+				NamespaceMapping: m.config.ClusterNamespaceMappings[name],
+			}, kubeconfigDirSource, kubeconfigs[name])
+		}
+	}, kubeconfigPollPeriod, stopCh)
+}
+
+// readKubeconfigDir returns the checksum of every kubeconfig in dir by
+// cluster name. Hidden entries such as ..data and the timestamped
+// directories of projected volumes are skipped, symlinks are followed.
+func readKubeconfigDir(dir string) (map[string][]byte, error) {
+	kubeconfigs := map[string][]byte{}
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return kubeconfigs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		checksum := sha256.Sum256(data)
+		kubeconfigs[file.Name()] = checksum[:]
+	}
+	return kubeconfigs, nil
+}
@@ -4,36 +4,311 @@
 package controller
 
 import (
+	"fmt"
 	c "github.com/vmware/k8s-endpoints-sync-controller/src/config"
 	"github.com/vmware/k8s-endpoints-sync-controller/src/handlers"
+	"github.com/vmware/k8s-endpoints-sync-controller/src/health"
 	log "github.com/vmware/k8s-endpoints-sync-controller/src/log"
+	"github.com/vmware/k8s-endpoints-sync-controller/src/metrics"
 	"k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/util/wait"
 	informercorev1 "k8s.io/client-go/informers/core/v1"
+	informerdiscoveryv1 "k8s.io/client-go/informers/discovery/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/workqueue"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-func StartController(kubeconfigPath string, eventHandler handlers.Handler, config *c.Config) error {
-	kubeClient, err := getkubeclient(kubeconfigPath)
+// connectRetryPeriod is the interval between attempts to reach the API
+// server of a remote cluster.
+const connectRetryPeriod = 10 * time.Second
+
+const (
+	namespaceKind = "namespace"
+	endpointsKind = "endpoints"
+	sliceKind     = "endpointslice"
+	serviceKind   = "service"
+)
+
+// queueKey identifies an object of a given kind by its namespace/name key.
+type queueKey struct {
+	kind string
+	key  string
+}
+
+// Controller watches a single remote cluster and reconciles the objects it
+// observes through a rate limited workqueue.
+type Controller struct {
+	name         string
+	kubeClient   *kubernetes.Clientset
+	eventHandler handlers.Handler
+	config       *c.Config
+	queue        workqueue.RateLimitingInterface
+	// informers holds the informers of every kind by namespace, or under
+	// v1.NamespaceAll when all namespaces are watched.
+	informers    map[string]map[string]cache.SharedIndexInformer
+	started      time.Time
+	stopCh       <-chan struct{}
+	workerCount  int
+	resyncPeriod time.Duration
+
+	// lastSync is the time of the last successful reconcile.
+	lastSyncLock sync.RWMutex
+	lastSync     time.Time
+
+	// workers is done once every worker has returned after stopCh closed.
+	workers sync.WaitGroup
+
+	syncedLock sync.RWMutex
+	synced     bool
+
+	// tombstones keeps the last known state of deleted objects until the
+	// delete has been reconciled, since the informer cache no longer has them.
+	tombstonesLock sync.Mutex
+	tombstones     map[queueKey]interface{}
+
+	// changed holds the keys of objects that changed in the remote cluster
+	// since they were last reconciled, resyncs are not tracked.
+	changedLock sync.Mutex
+	changed     map[queueKey]bool
+}
+
+var (
+	controllersLock sync.RWMutex
+	controllers     = map[string]*Controller{}
+)
+
+// flushQueues is set on shutdown when the workers of stopped controllers
+// should reconcile everything left in their queues before returning.
+var flushQueues int32
+
+// registerController makes ctrl visible to the garbage collector unless it
+// has been stopped in the meantime.
+func registerController(ctrl *Controller) bool {
+	controllersLock.Lock()
+	defer controllersLock.Unlock()
+	if ctrl.stopped() {
+		return false
+	}
+	controllers[ctrl.name] = ctrl
+	return true
+}
+
+// unregisterController forgets the controller of cluster name.
+func unregisterController(name string) {
+	controllersLock.Lock()
+	defer controllersLock.Unlock()
+	delete(controllers, name)
+}
+
+// registeredControllers returns the controllers of all running clusters by
+// cluster name.
+func registeredControllers() map[string]*Controller {
+	controllersLock.RLock()
+	defer controllersLock.RUnlock()
+	registered := make(map[string]*Controller, len(controllers))
+	for name, ctrl := range controllers {
+		registered[name] = ctrl
+	}
+	return registered
+}
+
+// StartController starts the informers of cluster right away so that their
+// caches are warm, but only starts reconciling once leading is closed. The
+// informers and workers run until stopCh is closed.
+func StartController(cluster c.ClusterConfig, eventHandler handlers.Handler, config *c.Config, leading <-chan struct{}, stopCh <-chan struct{}) error {
+	name := cluster.Name
+	kubeClient, err := getkubeclient(cluster)
 	if err != nil {
+		health.SetError(name, err)
 		return err
 	}
-	if config.WatchNamespaces {
-		watchNamespaces(kubeClient, eventHandler, config)
+	err = wait.PollImmediateUntil(connectRetryPeriod, func() (bool, error) {
+		if _, err := kubeClient.Discovery().ServerVersion(); err != nil {
+			log.Errorf("Error connecting to cluster %s, err %v", name, err)
+			health.SetError(name, err)
+			return false, nil
+		}
+		return true, nil
+	}, stopCh)
+	if err != nil {
+		log.Infof("Stopped connecting to cluster %s", name)
+		return nil
 	}
-	if config.WatchEndpoints {
-		watchEndpoints(kubeClient, eventHandler, config)
+	health.SetConnected(name)
+	rateLimiter := workqueue.NewItemExponentialFailureRateLimiter(config.RetryBaseDelay, config.RetryMaxDelay)
+	ctrl := &Controller{
+		name:         name,
+		kubeClient:   kubeClient,
+		eventHandler: eventHandler,
+		config:       config,
+		queue:        workqueue.NewNamedRateLimitingQueue(rateLimiter, name),
+		informers:    map[string]map[string]cache.SharedIndexInformer{},
+		started:      time.Now(),
+		stopCh:       stopCh,
+		workerCount:  config.Workers,
+		resyncPeriod: config.ResyncPeriod,
+		tombstones:   map[queueKey]interface{}{},
+		changed:      map[queueKey]bool{},
 	}
-	if config.WatchServices {
-		watchServices(kubeClient, eventHandler, config)
+	if cluster.Workers > 0 {
+		ctrl.workerCount = cluster.Workers
 	}
+	if cluster.ResyncPeriod > 0 {
+		ctrl.resyncPeriod = cluster.ResyncPeriod
+	}
+	for _, namespace := range ctrl.namespaces() {
+		if config.WatchNamespaces {
+			ctrl.watchNamespaces(namespace, stopCh)
+		}
+		if config.WatchEndpoints {
+			ctrl.watchEndpoints(namespace, stopCh)
+		}
+		if config.WatchEndpointSlices {
+			ctrl.watchEndpointSlices(namespace, stopCh)
+		}
+		if config.WatchServices {
+			ctrl.watchServices(namespace, stopCh)
+		}
+	}
+	if !registerController(ctrl) {
+		ctrl.queue.ShutDown()
+		return nil
+	}
+	go ctrl.waitForSync()
+	ctrl.workers.Add(ctrl.workerCount)
+	go func() {
+		select {
+		case <-leading:
+		case <-stopCh:
+			for i := 0; i < ctrl.workerCount; i++ {
+				ctrl.workers.Done()
+			}
+			return
+		}
+		log.Infof("Starting workers for cluster %s", ctrl.name)
+		for i := 0; i < ctrl.workerCount; i++ {
+			go func() {
+				defer ctrl.workers.Done()
+				wait.Until(ctrl.runWorker, time.Second, stopCh)
+			}()
+		}
+	}()
+	go func() {
+		<-stopCh
+		log.Infof("Stopping controller of cluster %s", ctrl.name)
+		ctrl.queue.ShutDown()
+	}()
 	return nil
 }
 
-func getkubeclient(kubeconfigPath string) (*kubernetes.Clientset, error) {
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+// waitForSync marks the cluster as synced once all of its informers have
+// synced their caches.
+func (ctrl *Controller) waitForSync() {
+	var synced []cache.InformerSynced
+	for _, informers := range ctrl.informers {
+		for _, informer := range informers {
+			synced = append(synced, informer.HasSynced)
+		}
+	}
+	log.Infof("Waiting for caches of cluster %s to be synced", ctrl.name)
+	if cache.WaitForCacheSync(ctrl.stopCh, synced...) {
+		log.Infof("synced caches of cluster %s", ctrl.name)
+		ctrl.syncedLock.Lock()
+		ctrl.synced = true
+		ctrl.syncedLock.Unlock()
+		health.SetSynced(ctrl.name)
+	}
+}
+
+// hasSynced reports whether all informers of the cluster have synced.
+func (ctrl *Controller) hasSynced() bool {
+	ctrl.syncedLock.RLock()
+	defer ctrl.syncedLock.RUnlock()
+	return ctrl.synced
+}
+
+// ClusterStatus is the replication state of a remote cluster beyond what is
+// tracked by the health package.
+type ClusterStatus struct {
+	LastSyncTime time.Time
+	ObjectCounts map[string]int
+}
+
+// GetClusterStatus returns the state of the running controller of cluster
+// name, if any.
+func GetClusterStatus(name string) (ClusterStatus, bool) {
+	ctrl, ok := registeredControllers()[name]
+	if !ok {
+		return ClusterStatus{}, false
+	}
+	status := ClusterStatus{ObjectCounts: map[string]int{}}
+	ctrl.lastSyncLock.RLock()
+	status.LastSyncTime = ctrl.lastSync
+	ctrl.lastSyncLock.RUnlock()
+	for kind, informers := range ctrl.informers {
+		for _, informer := range informers {
+			status.ObjectCounts[kind] += len(informer.GetStore().ListKeys())
+		}
+	}
+	return status, true
+}
+
+// has reports whether the cache of the cluster holds an object of kind under
+// key.
+func (ctrl *Controller) has(kind string, key string) bool {
+	informer, ok := ctrl.informer(kind, key)
+	if !ok {
+		return false
+	}
+	_, exists, err := informer.GetIndexer().GetByKey(key)
+	return err == nil && exists
+}
+
+// informer returns the informer watching the object of kind under key.
+func (ctrl *Controller) informer(kind string, key string) (cache.SharedIndexInformer, bool) {
+	informers := ctrl.informers[kind]
+	if informer, ok := informers[v1.NamespaceAll]; ok {
+		return informer, true
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return nil, false
+	}
+	if kind == namespaceKind {
+		namespace = name
+	}
+	informer, ok := informers[namespace]
+	return informer, ok
+}
+
+// namespaces returns the namespaces to watch in the remote cluster. Watching
+// only some of them needs namespaced roles for services and endpoints there,
+// but namespaces themselves are cluster-scoped and still need a ClusterRole.
+func (ctrl *Controller) namespaces() []string {
+	if len(ctrl.config.NamespacesToWatch) == 0 {
+		return []string{v1.NamespaceAll}
+	}
+	return ctrl.config.NamespacesToWatch
+}
+
+func getkubeclient(cluster c.ClusterConfig) (*kubernetes.Clientset, error) {
+	var config *rest.Config
+	var err error
+	if cluster.Kubeconfig != nil {
+		config, err = clientcmd.RESTConfigFromKubeConfig(cluster.Kubeconfig)
+	} else {
+		config, err = clientcmd.BuildConfigFromFlags("", cluster.KubeconfigPath)
+	}
 	log.Infof("building kubeclient")
 	if err != nil {
 		log.Errorf("Error with kubeconfig %s", err)
@@ -46,53 +321,302 @@ func getkubeclient(kubeconfigPath string) (*kubernetes.Clientset, error) {
 	return clientset, nil
 }
 
-func watchNamespaces(client *kubernetes.Clientset, eventHandler handlers.Handler, config *c.Config) cache.Store {
+func getlocalrestconfig() (*rest.Config, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Errorf("Error fetching incluster config %s", err)
+		return nil, err
+	}
+	return config, nil
+}
+
+func getlocalkubeclient() (*kubernetes.Clientset, error) {
+	config, err := getlocalrestconfig()
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Errorf("Error creating client with inclusterConfig, %s", err)
+		return nil, err
+	}
+	return clientset, nil
+}
+
+// watchNamespaces watches namespace itself, or all namespaces if it is
+// v1.NamespaceAll. Either way it lists cluster-scoped namespaces.
+func (ctrl *Controller) watchNamespaces(namespace string, stopCh <-chan struct{}) {
 
 	indexers := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
-	informer := informercorev1.NewNamespaceInformer(client, 0, indexers)
+	informer := informercorev1.NewFilteredNamespaceInformer(ctrl.kubeClient, 0, indexers, func(options *meta_v1.ListOptions) {
+		if namespace != v1.NamespaceAll {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", namespace).String()
+		}
+	})
 
-	informer.AddEventHandler(
-		cache.ResourceEventHandlerFuncs{
-			AddFunc:    eventHandler.ObjectCreated,
-			UpdateFunc: eventHandler.ObjectUpdated,
-			DeleteFunc: eventHandler.ObjectDeleted,
-		},
-	)
-	go informer.Run(wait.NeverStop)
+	ctrl.addInformer(namespaceKind, namespace, informer)
+	go informer.Run(stopCh)
 	log.Infof("Waiting for namespaces to be synced")
-	cache.WaitForCacheSync(wait.NeverStop, informer.HasSynced)
+	cache.WaitForCacheSync(stopCh, informer.HasSynced)
 	log.Infof("synced namespaces")
-
-	return nil
 }
 
-func watchEndpoints(client *kubernetes.Clientset, eventHandler handlers.Handler, config *c.Config) cache.Store {
+func (ctrl *Controller) watchEndpoints(namespace string, stopCh <-chan struct{}) {
 
 	indexers := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
-	informer := informercorev1.NewEndpointsInformer(client, v1.NamespaceAll, config.ResyncPeriod, indexers)
+	informer := informercorev1.NewEndpointsInformer(ctrl.kubeClient, namespace, ctrl.resyncPeriod, indexers)
 
-	informer.AddEventHandler(
-		cache.ResourceEventHandlerFuncs{
-			AddFunc:    eventHandler.ObjectCreated,
-			UpdateFunc: eventHandler.ObjectUpdated,
-			DeleteFunc: eventHandler.ObjectDeleted,
-		},
-	)
-	go informer.Run(wait.NeverStop)
-	return nil
+	ctrl.addInformer(endpointsKind, namespace, informer)
+	go informer.Run(stopCh)
+}
+
+func (ctrl *Controller) watchEndpointSlices(namespace string, stopCh <-chan struct{}) {
+	indexers := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
+	informer := informerdiscoveryv1.NewEndpointSliceInformer(ctrl.kubeClient, namespace, ctrl.resyncPeriod, indexers)
+
+	ctrl.addInformer(sliceKind, namespace, informer)
+	go informer.Run(stopCh)
 }
 
-func watchServices(client *kubernetes.Clientset, eventHandler handlers.Handler, config *c.Config) cache.Store {
+func (ctrl *Controller) watchServices(namespace string, stopCh <-chan struct{}) {
 	indexers := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
-	informer := informercorev1.NewServiceInformer(client, v1.NamespaceAll, config.ResyncPeriod, indexers)
+	informer := informercorev1.NewServiceInformer(ctrl.kubeClient, namespace, ctrl.resyncPeriod, indexers)
 
+	ctrl.addInformer(serviceKind, namespace, informer)
+	go informer.Run(stopCh)
+}
+
+// addInformer registers the informer of namespace under kind and enqueues the
+// namespace/name key of every object it reports.
+func (ctrl *Controller) addInformer(kind string, namespace string, informer cache.SharedIndexInformer) {
+	if ctrl.informers[kind] == nil {
+		ctrl.informers[kind] = map[string]cache.SharedIndexInformer{}
+	}
+	ctrl.informers[kind][namespace] = informer
 	informer.AddEventHandler(
 		cache.ResourceEventHandlerFuncs{
-			AddFunc:    eventHandler.ObjectCreated,
-			UpdateFunc: eventHandler.ObjectUpdated,
-			DeleteFunc: eventHandler.ObjectDeleted,
+			AddFunc: func(obj interface{}) {
+				metrics.EventsReceived.WithLabelValues(ctrl.name, kind).Inc()
+				ctrl.enqueue(kind, obj, informer.HasSynced())
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				metrics.EventsReceived.WithLabelValues(ctrl.name, kind).Inc()
+				ctrl.enqueue(kind, newObj, resourceVersion(oldObj) != resourceVersion(newObj))
+			},
+			DeleteFunc: func(obj interface{}) {
+				metrics.EventsReceived.WithLabelValues(ctrl.name, kind).Inc()
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				key, err := cache.MetaNamespaceKeyFunc(obj)
+				if err != nil {
+					log.Errorf("Error computing key for deleted %s, err %v", kind, err)
+					return
+				}
+				ctrl.tombstonesLock.Lock()
+				ctrl.tombstones[queueKey{kind: kind, key: key}] = obj
+				ctrl.tombstonesLock.Unlock()
+				ctrl.queue.Add(queueKey{kind: kind, key: key})
+			},
 		},
 	)
-	go informer.Run(wait.NeverStop)
+}
+
+// enqueue adds the key of obj to the queue, changed tells whether obj was
+// modified in the remote cluster rather than resynced or listed at startup.
+func (ctrl *Controller) enqueue(kind string, obj interface{}, changed bool) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		log.Errorf("Error computing key for %s, err %v", kind, err)
+		return
+	}
+	if changed {
+		ctrl.changedLock.Lock()
+		ctrl.changed[queueKey{kind: kind, key: key}] = true
+		ctrl.changedLock.Unlock()
+	}
+	ctrl.queue.Add(queueKey{kind: kind, key: key})
+}
+
+// observeLag records the replication lag of obj if it changed in the remote
+// cluster since it was last reconciled. Changes older than the controller are
+// ignored so the initial list does not skew the histogram.
+func (ctrl *Controller) observeLag(key queueKey, obj interface{}) {
+	ctrl.changedLock.Lock()
+	changed := ctrl.changed[key]
+	delete(ctrl.changed, key)
+	ctrl.changedLock.Unlock()
+	if !changed {
+		return
+	}
+	if changeTime, ok := lastChangeTime(obj); ok && changeTime.After(ctrl.started) {
+		metrics.ReplicationLag.WithLabelValues(ctrl.name, key.kind).Observe(time.Since(changeTime).Seconds())
+	}
+}
+
+// lastChangeTime returns the time obj was last changed, taken from the
+// last-change-trigger-time annotation of endpoints and endpointslices or the
+// most recent managed fields entry.
+func lastChangeTime(obj interface{}) (time.Time, bool) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if val, ok := accessor.GetAnnotations()[v1.EndpointsLastChangeTriggerTime]; ok {
+		if t, err := time.Parse(time.RFC3339Nano, val); err == nil {
+			return t, true
+		}
+	}
+	var last time.Time
+	for _, entry := range accessor.GetManagedFields() {
+		if entry.Time != nil && entry.Time.After(last) {
+			last = entry.Time.Time
+		}
+	}
+	return last, !last.IsZero()
+}
+
+func resourceVersion(obj interface{}) string {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return ""
+	}
+	return accessor.GetResourceVersion()
+}
+
+// stopped reports whether the controller has been asked to stop.
+func (ctrl *Controller) stopped() bool {
+	select {
+	case <-ctrl.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+func (ctrl *Controller) runWorker() {
+	for ctrl.processNextItem() {
+	}
+}
+
+func (ctrl *Controller) processNextItem() bool {
+	item, quit := ctrl.queue.Get()
+	if quit {
+		return false
+	}
+	defer ctrl.queue.Done(item)
+
+	key := item.(queueKey)
+	if ctrl.stopped() && atomic.LoadInt32(&flushQueues) == 0 {
+		return false
+	}
+	ctrl.handleErr(ctrl.reconcile(key), key)
+	return true
+}
+
+// reconcile hands the current state of the object identified by key to the
+// event handler, or its last known state if it has been deleted.
+func (ctrl *Controller) reconcile(key queueKey) error {
+	informer, ok := ctrl.informer(key.kind, key.key)
+	if !ok {
+		return fmt.Errorf("no informer for %s %s", key.kind, key.key)
+	}
+	obj, exists, err := informer.GetIndexer().GetByKey(key.key)
+	if err != nil {
+		return err
+	}
+
+	ctrl.tombstonesLock.Lock()
+	deleted, deletedExists := ctrl.tombstones[key]
+	ctrl.tombstonesLock.Unlock()
+
+	if exists {
+		if deletedExists {
+			ctrl.forgetTombstone(key)
+		}
+		if err := ctrl.eventHandler.ObjectSynced(ctrl.name, obj); err != nil {
+			return err
+		}
+		ctrl.observeLag(key, obj)
+		if key.kind == serviceKind && (ctrl.config.ExportOptIn || ctrl.config.ReplicationPolicies) {
+			ctrl.enqueueServiceEndpoints(key.key)
+		}
+		return nil
+	}
+	if !deletedExists {
+		return nil
+	}
+	if err := ctrl.eventHandler.ObjectDeleted(ctrl.name, deleted); err != nil {
+		return err
+	}
+	ctrl.forgetTombstone(key)
 	return nil
 }
+
+// RequeueServices adds the services of every remote cluster to the queues,
+// for changed replication policies to apply to them.
+func RequeueServices() {
+	for _, ctrl := range registeredControllers() {
+		for _, informer := range ctrl.informers[serviceKind] {
+			for _, obj := range informer.GetStore().List() {
+				ctrl.enqueue(serviceKind, obj, false)
+			}
+		}
+	}
+}
+
+// enqueueServiceEndpoints adds the endpoints and endpointslices of the
+// service with key to the queue, for them to follow the service being
+// exported or no longer.
+func (ctrl *Controller) enqueueServiceEndpoints(key string) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return
+	}
+	for _, endpointsKey := range []string{key, key + "-syndicate"} {
+		if _, ok := ctrl.informer(endpointsKind, endpointsKey); ok {
+			ctrl.queue.Add(queueKey{kind: endpointsKind, key: endpointsKey})
+		}
+	}
+	informer, ok := ctrl.informer(sliceKind, key)
+	if !ok {
+		return
+	}
+	slices, err := informer.GetIndexer().ByIndex(cache.NamespaceIndex, namespace)
+	if err != nil {
+		log.Errorf("Error listing endpointslices of service %s, err %v", key, err)
+		return
+	}
+	for _, obj := range slices {
+		if slice, ok := obj.(*discoveryv1.EndpointSlice); ok && slice.Labels[discoveryv1.LabelServiceName] == name {
+			ctrl.enqueue(sliceKind, slice, false)
+		}
+	}
+}
+
+func (ctrl *Controller) handleErr(err error, key queueKey) {
+	if err == nil {
+		ctrl.lastSyncLock.Lock()
+		ctrl.lastSync = time.Now()
+		ctrl.lastSyncLock.Unlock()
+		ctrl.queue.Forget(key)
+		return
+	}
+	if ctrl.queue.NumRequeues(key) < ctrl.config.MaxRetries {
+		log.Infof("Error reconciling %s %s from cluster %s, retrying: %v", key.kind, key.key, ctrl.name, err)
+		ctrl.queue.AddRateLimited(key)
+		return
+	}
+	log.Errorf("Dropping %s %s from cluster %s after %d retries: %v", key.kind, key.key, ctrl.name, ctrl.config.MaxRetries, err)
+	ctrl.queue.Forget(key)
+	ctrl.forgetTombstone(key)
+	ctrl.changedLock.Lock()
+	delete(ctrl.changed, key)
+	ctrl.changedLock.Unlock()
+}
+
+func (ctrl *Controller) forgetTombstone(key queueKey) {
+	ctrl.tombstonesLock.Lock()
+	delete(ctrl.tombstones, key)
+	ctrl.tombstonesLock.Unlock()
+}
@@ -0,0 +1,328 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package controller
+
+import (
+	"context"
+	c "github.com/vmware/k8s-endpoints-sync-controller/src/config"
+	"github.com/vmware/k8s-endpoints-sync-controller/src/handlers"
+	log "github.com/vmware/k8s-endpoints-sync-controller/src/log"
+	"k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"strings"
+	"time"
+)
+
+// gcTrigger requests a garbage collection before the next period.
+var gcTrigger = make(chan struct{}, 1)
+
+// TriggerGarbageCollection makes the garbage collector run as soon as all
+// clusters are synced.
+func TriggerGarbageCollection() {
+	select {
+	case gcTrigger <- struct{}{}:
+	default:
+	}
+}
+
+// RunGarbageCollector waits for the caches of all configured clusters to
+// sync and then periodically compares the replicated objects of the local
+// cluster against them. Orphans, whose source no longer exists in any remote
+// cluster, are deleted through eventHandler, the others are requeued so that
+// stale copies get repaired. Whether an object is a replica is decided by its
+// replicated label alone. It returns once stopCh is closed.
+func RunGarbageCollector(eventHandler handlers.Handler, config *c.Config, stopCh <-chan struct{}) error {
+	client, err := getlocalkubeclient()
+	if err != nil {
+		return err
+	}
+	err = wait.PollImmediateUntil(connectRetryPeriod, func() (bool, error) {
+		return allSynced(config), nil
+	}, stopCh)
+	if err != nil {
+		return nil
+	}
+	ticker := time.NewTicker(config.GCPeriod)
+	defer ticker.Stop()
+	for {
+		if !allSynced(config) {
+			log.Infof("Skipping garbage collection, not all clusters are synced")
+		} else if err := collectGarbage(client, eventHandler, config); err != nil {
+			log.Errorf("Error collecting garbage %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-gcTrigger:
+		case <-stopCh:
+			return nil
+		}
+	}
+}
+
+// allSynced reports whether every configured cluster has a running
+// controller whose caches have synced.
+func allSynced(config *c.Config) bool {
+	controllers := registeredControllers()
+	clusters := config.Clusters()
+	if len(controllers) == 0 || len(controllers) != len(clusters) {
+		return false
+	}
+	for _, cluster := range clusters {
+		if ctrl, ok := controllers[cluster.Name]; !ok || !ctrl.hasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+func collectGarbage(client kubernetes.Interface, eventHandler handlers.Handler, config *c.Config) error {
+	log.Infof("Collecting garbage of replicated objects")
+	start := time.Now()
+	controllers := registeredControllers()
+	options := meta_v1.ListOptions{
+		LabelSelector: labels.Set{c.REPLICATED_LABEL_KEY: config.ReplicatedLabelVal}.AsSelector().String(),
+	}
+
+	if config.WatchNamespaces {
+		namespaces, err := client.CoreV1().Namespaces().List(context.TODO(), options)
+		if err != nil {
+			return err
+		}
+		for i := range namespaces.Items {
+			ns := &namespaces.Items[i]
+			if err := collectObject(controllers, eventHandler, config, namespaceKind, ns); err != nil {
+				return err
+			}
+		}
+	}
+
+	if config.WatchServices {
+		services, err := client.CoreV1().Services(v1.NamespaceAll).List(context.TODO(), options)
+		if err != nil {
+			return err
+		}
+		for i := range services.Items {
+			svc := &services.Items[i]
+			if svc.Labels[c.REPLICA_NAMING_LABEL_KEY] == c.SERVICE_NAMING_PER_CLUSTER {
+				err = collectPerClusterReplica(controllers, eventHandler, config, serviceKind, svc)
+			} else {
+				err = collectObject(controllers, eventHandler, config, serviceKind, svc)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if config.WatchEndpoints {
+		endpointsList, err := client.CoreV1().Endpoints(v1.NamespaceAll).List(context.TODO(), options)
+		if err != nil {
+			return err
+		}
+		for i := range endpointsList.Items {
+			endpoints := &endpointsList.Items[i]
+			if endpoints.Labels[c.REPLICA_NAMING_LABEL_KEY] == c.SERVICE_NAMING_PER_CLUSTER {
+				err = collectPerClusterReplica(controllers, eventHandler, config, endpointsKind, endpoints)
+			} else {
+				err = collectEndpoints(controllers, eventHandler, config, endpoints)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if config.WatchEndpointSlices {
+		slices, err := client.DiscoveryV1().EndpointSlices(v1.NamespaceAll).List(context.TODO(), options)
+		if err != nil {
+			return err
+		}
+		for i := range slices.Items {
+			slice := &slices.Items[i]
+			if slice.Labels[c.REPLICA_NAMING_LABEL_KEY] == c.SERVICE_NAMING_PER_CLUSTER {
+				err = collectPerClusterReplica(controllers, eventHandler, config, sliceKind, slice)
+			} else {
+				err = collectEndpointSlice(controllers, eventHandler, config, slice)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	log.Infof("Collected garbage of replicated objects in %v", time.Since(start))
+	return nil
+}
+
+// collectObject deletes the local replica obj if no remote cluster has its
+// source object, or requeues the source in every cluster that has one. It is
+// kept if a cluster does not watch where its source would be, as with
+// NSTOWATCH.
+func collectObject(controllers map[string]*Controller, eventHandler handlers.Handler, config *c.Config, kind string, obj interface{}) error {
+	found := false
+	for cluster, ctrl := range controllers {
+		key, err := cache.MetaNamespaceKeyFunc(asRemoteObject(config, cluster, obj))
+		if err != nil {
+			return err
+		}
+		if _, ok := ctrl.informer(kind, key); !ok {
+			found = true
+			continue
+		}
+		if ctrl.has(kind, key) {
+			found = true
+			ctrl.queue.Add(queueKey{kind: kind, key: key})
+		}
+	}
+	if found {
+		return nil
+	}
+	key, _ := cache.MetaNamespaceKeyFunc(obj)
+	log.Infof("Deleting orphaned %s %s", kind, key)
+	return eventHandler.DeleteReplica(obj)
+}
+
+// collectEndpoints drops the addresses of every source cluster of endpoints
+// that no longer has them and requeues the endpoints in the others.
+func collectEndpoints(controllers map[string]*Controller, eventHandler handlers.Handler, config *c.Config, endpoints *v1.Endpoints) error {
+	sources := handlers.EndpointSourceClusters(endpoints)
+	if len(sources) == 0 {
+		return collectObject(controllers, eventHandler, config, endpointsKind, endpoints)
+	}
+	for _, cluster := range sources {
+		key := config.RemoteNamespace(cluster, endpoints.Namespace) + "/" + endpoints.Name
+		ctrl, ok := controllers[cluster]
+		if ok && (ctrl.has(endpointsKind, key) || ctrl.has(endpointsKind, key+"-syndicate")) {
+			ctrl.queue.Add(queueKey{kind: endpointsKind, key: key})
+			continue
+		}
+		if ok {
+			if _, watched := ctrl.informer(endpointsKind, key); !watched {
+				continue
+			}
+		}
+		log.Infof("Removing orphaned addresses of cluster %s from endpoints %s/%s", cluster, endpoints.Namespace, endpoints.Name)
+		if err := eventHandler.RemoveEndpointsSource(cluster, endpoints); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectEndpointSlice deletes a local endpointslice whose source slice no
+// longer exists in its cluster.
+func collectEndpointSlice(controllers map[string]*Controller, eventHandler handlers.Handler, config *c.Config, slice *discoveryv1.EndpointSlice) error {
+	cluster := slice.Labels[c.EPS_LABEL_SOURCE_CLUSTER_KEY]
+	if cluster == "" {
+		return nil
+	}
+	remoteSlice := asRemoteObject(config, cluster, slice).(*discoveryv1.EndpointSlice)
+	name, ok := sourceSliceName(slice)
+	if !ok {
+		log.Infof("Deleting endpointslice %s/%s of the former naming", slice.Namespace, slice.Name)
+		return eventHandler.DeleteReplica(slice)
+	}
+	remoteSlice.Name = name
+	delete(remoteSlice.Labels, discoveryv1.LabelManagedBy)
+	key := remoteSlice.Namespace + "/" + remoteSlice.Name
+	if ctrl, ok := controllers[cluster]; ok {
+		if _, watched := ctrl.informer(sliceKind, key); !watched {
+			return nil
+		}
+		if ctrl.has(sliceKind, key) {
+			ctrl.queue.Add(queueKey{kind: sliceKind, key: key})
+			return nil
+		}
+	}
+	log.Infof("Deleting orphaned endpointslice %s/%s", slice.Namespace, slice.Name)
+	return eventHandler.DeleteReplica(slice)
+}
+
+// sourceSliceName returns the name of the slice that the local endpointslice
+// slice replicates. It reports false for slices replicated before the source
+// name was recorded, as they are named differently from their replacements.
+func sourceSliceName(slice *discoveryv1.EndpointSlice) (string, bool) {
+	name, ok := slice.Annotations[c.EPS_ANNOTATION_SOURCE_NAME_KEY]
+	return name, ok
+}
+
+// collectPerClusterReplica deletes the per-cluster replica obj if its source
+// no longer exists in its cluster or services are no longer replicated per
+// cluster, or requeues the source otherwise. With replication policies the
+// naming is decided per service by the handler.
+func collectPerClusterReplica(controllers map[string]*Controller, eventHandler handlers.Handler, config *c.Config, kind string, obj interface{}) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	cluster := accessor.GetLabels()[c.EPS_LABEL_SOURCE_CLUSTER_KEY]
+	if cluster == "" {
+		return nil
+	}
+	remote := asRemoteObject(config, cluster, obj)
+	remoteAccessor, err := meta.Accessor(remote)
+	if err != nil {
+		return err
+	}
+	if kind == sliceKind {
+		name, ok := sourceSliceName(obj.(*discoveryv1.EndpointSlice))
+		if !ok {
+			log.Infof("Deleting per-cluster endpointslice %s/%s of the former naming", accessor.GetNamespace(), accessor.GetName())
+			return eventHandler.DeleteReplica(obj)
+		}
+		remoteAccessor.SetName(name)
+		delete(remoteAccessor.GetLabels(), discoveryv1.LabelManagedBy)
+	} else {
+		remoteAccessor.SetName(strings.TrimSuffix(accessor.GetName(), "-"+cluster))
+	}
+	key, err := cache.MetaNamespaceKeyFunc(remote)
+	if err != nil {
+		return err
+	}
+	if ctrl, ok := controllers[cluster]; ok {
+		if _, watched := ctrl.informer(kind, key); !watched {
+			return nil
+		}
+		if (config.PerClusterNames() || config.ReplicationPolicies) && ctrl.has(kind, key) {
+			ctrl.queue.Add(queueKey{kind: kind, key: key})
+			return nil
+		}
+	}
+	log.Infof("Deleting orphaned per-cluster %s %s/%s", kind, accessor.GetNamespace(), accessor.GetName())
+	return eventHandler.DeleteReplica(obj)
+}
+
+// asRemoteObject returns a copy of the local replica obj as its source in
+// cluster would look: in the namespace of cluster mapped to the local one and
+// without the replicated label, as the handler ignores objects carrying it.
+func asRemoteObject(config *c.Config, cluster string, obj interface{}) interface{} {
+	switch v := obj.(type) {
+	case *v1.Namespace:
+		v = v.DeepCopy()
+		v.Name = config.RemoteNamespace(cluster, v.Name)
+		delete(v.Labels, c.REPLICATED_LABEL_KEY)
+		return v
+	case *v1.Service:
+		v = v.DeepCopy()
+		v.Namespace = config.RemoteNamespace(cluster, v.Namespace)
+		delete(v.Labels, c.REPLICATED_LABEL_KEY)
+		return v
+	case *v1.Endpoints:
+		v = v.DeepCopy()
+		v.Namespace = config.RemoteNamespace(cluster, v.Namespace)
+		delete(v.Labels, c.REPLICATED_LABEL_KEY)
+		return v
+	case *discoveryv1.EndpointSlice:
+		v = v.DeepCopy()
+		v.Namespace = config.RemoteNamespace(cluster, v.Namespace)
+		delete(v.Labels, c.REPLICATED_LABEL_KEY)
+		return v
+	}
+	return obj
+}
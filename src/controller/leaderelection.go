@@ -0,0 +1,63 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package controller
+
+import (
+	"context"
+	c "github.com/vmware/k8s-endpoints-sync-controller/src/config"
+	log "github.com/vmware/k8s-endpoints-sync-controller/src/log"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// RunLeaderElection campaigns for the replication Lease in the local cluster
+// and blocks until leadership is lost or ctx is cancelled, in which case the
+// Lease is released. onStartedLeading is called once this replica becomes the
+// leader, onStoppedLeading when it loses the Lease.
+func RunLeaderElection(ctx context.Context, config *c.Config, onStartedLeading func(), onStoppedLeading func()) error {
+	client, err := getlocalkubeclient()
+	if err != nil {
+		return err
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: meta_v1.ObjectMeta{
+			Name:      config.LeaseName,
+			Namespace: config.LeaseNamespace,
+		},
+		Client: client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: config.LeaseIdentity,
+		},
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   config.LeaseDuration,
+		RenewDeadline:   config.RenewDeadline,
+		RetryPeriod:     config.RetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Infof("%s became the leader", config.LeaseIdentity)
+				onStartedLeading()
+			},
+			OnStoppedLeading: func() {
+				log.Infof("%s stopped leading", config.LeaseIdentity)
+				onStoppedLeading()
+			},
+			OnNewLeader: func(identity string) {
+				if identity != config.LeaseIdentity {
+					log.Infof("current leader is %s, waiting as standby", identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		log.Errorf("Error creating leader elector %s", err)
+		return err
+	}
+	elector.Run(ctx)
+	return nil
+}
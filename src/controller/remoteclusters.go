@@ -0,0 +1,248 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	c "github.com/vmware/k8s-endpoints-sync-controller/src/config"
+	"github.com/vmware/k8s-endpoints-sync-controller/src/health"
+	log "github.com/vmware/k8s-endpoints-sync-controller/src/log"
+	"github.com/vmware/k8s-endpoints-sync-controller/src/remotecluster"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"time"
+)
+
+// remoteClusterResyncPeriod is the interval at which every RemoteCluster is
+// synced again, picking up rotated credentials in its Secret.
+const remoteClusterResyncPeriod = time.Minute
+
+// remoteClusterStatusPeriod is the interval at which the status of every
+// RemoteCluster is updated.
+const remoteClusterStatusPeriod = 30 * time.Second
+
+// WatchRemoteClusters keeps a controller running for every RemoteCluster in
+// namespace, using the kubeconfig of the Secret it references. The status of
+// every RemoteCluster is kept up to date by the leader.
+func (m *ClusterManager) WatchRemoteClusters(namespace string, stopCh <-chan struct{}) error {
+	restConfig, err := getlocalrestconfig()
+	if err != nil {
+		return err
+	}
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		log.Errorf("Error creating dynamic client with inclusterConfig, %s", err)
+		return err
+	}
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		log.Errorf("Error creating client with inclusterConfig, %s", err)
+		return err
+	}
+
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient, remoteClusterResyncPeriod, namespace, nil)
+	informer := factory.ForResource(remotecluster.Resource).Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			m.syncRemoteCluster(kubeClient, obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			m.syncRemoteCluster(kubeClient, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if u, ok := obj.(*unstructured.Unstructured); ok {
+				m.stopSource(remoteClusterSource(u.GetNamespace(), u.GetName()), "")
+				m.lock.Lock()
+				delete(m.errors, u.GetName())
+				m.lock.Unlock()
+			}
+		},
+	})
+	log.Infof("Watching remote clusters in namespace %s", namespace)
+	go informer.Run(stopCh)
+
+	go func() {
+		select {
+		case <-m.leading:
+		case <-stopCh:
+			return
+		}
+		wait.Until(func() {
+			for _, obj := range informer.GetStore().List() {
+				if err := m.updateRemoteClusterStatus(dynamicClient, obj.(*unstructured.Unstructured)); err != nil {
+					log.Errorf("Error updating status of remote cluster %s, err %v", obj.(*unstructured.Unstructured).GetName(), err)
+				}
+			}
+		}, remoteClusterStatusPeriod, stopCh)
+	}()
+	return nil
+}
+
+// syncRemoteCluster starts the cluster described by obj, restarts it if its
+// spec or credentials changed, or pauses it.
+func (m *ClusterManager) syncRemoteCluster(kubeClient kubernetes.Interface, obj interface{}) {
+	rc, err := remotecluster.FromUnstructured(obj.(*unstructured.Unstructured))
+	if err != nil {
+		log.Errorf("Error decoding remote cluster, err %v", err)
+		return
+	}
+	cluster, checksum, err := m.remoteClusterConfig(kubeClient, rc)
+	m.lock.Lock()
+	if err != nil {
+		m.errors[rc.Name] = err.Error()
+	} else {
+		delete(m.errors, rc.Name)
+	}
+	running, ok := m.clusters[rc.Name]
+	m.lock.Unlock()
+	if err != nil {
+		log.Errorf("Error reading remote cluster %s, err %v", rc.Name, err)
+		return
+	}
+
+	source := remoteClusterSource(rc.Namespace, rc.Name)
+	if ok && running.source == source && bytes.Equal(running.checksum, checksum) {
+		return
+	}
+	if rc.Spec.Options.Paused {
+		m.pause(cluster, source, checksum)
+		return
+	}
+	log.Infof("Spec or credentials of remote cluster %s changed", rc.Name)
+	m.start(cluster, source, checksum)
+}
+
+// remoteClusterConfig returns the settings of rc and a checksum of them.
+func (m *ClusterManager) remoteClusterConfig(kubeClient kubernetes.Interface, rc *remotecluster.RemoteCluster) (c.ClusterConfig, []byte, error) {
+	ref := rc.Spec.KubeconfigSecretRef
+	secret, err := kubeClient.CoreV1().Secrets(rc.Namespace).Get(context.TODO(), ref.Name, meta_v1.GetOptions{})
+	if err != nil {
+		return c.ClusterConfig{}, nil, err
+	}
+	kubeconfig, ok := secret.Data[ref.KubeconfigKey()]
+	if !ok {
+		return c.ClusterConfig{}, nil, fmt.Errorf("secret %s has no key %s", ref.Name, ref.KubeconfigKey())
+	}
+	podCIDRs := m.config.ClusterPodCIDRs[rc.Name]
+	if len(rc.Spec.PodCIDRs) > 0 {
+		podCIDRs = nil
+		for _, cidr := range rc.Spec.PodCIDRs {
+			parsed, err := parseCIDRs(cidr)
+			if err != nil {
+				return c.ClusterConfig{}, nil, err
+			}
+			podCIDRs = append(podCIDRs, parsed...)
+		}
+	}
+	namespaceMapping := m.config.ClusterNamespaceMappings[rc.Name]
+	if len(rc.Spec.NamespaceMapping) > 0 {
+		if errs := c.ValidateNamespaceMapping(field.NewPath("spec", "namespaceMapping"), rc.Spec.NamespaceMapping); len(errs) > 0 {
+			return c.ClusterConfig{}, nil, errs.ToAggregate()
+		}
+		namespaceMapping = rc.Spec.NamespaceMapping
+	}
+	spec, err := json.Marshal(rc.Spec)
+	if err != nil {
+		return c.ClusterConfig{}, nil, err
+	}
+	hash := sha256.New()
+	hash.Write(kubeconfig)
+	hash.Write(spec)
+	return c.ClusterConfig{
+		Name:             rc.Name,
+		Kubeconfig:       kubeconfig,
+		PodCIDRs:         podCIDRs,
+		Workers:          rc.Spec.Options.Workers,
+		ResyncPeriod:     rc.Spec.Options.ResyncPeriod.Duration,
+		NamespaceMapping: namespaceMapping,
+	}, hash.Sum(nil), nil
+}
+
+// updateRemoteClusterStatus writes the current state of the cluster of obj
+// to its status if it changed.
+func (m *ClusterManager) updateRemoteClusterStatus(dynamicClient dynamic.Interface, obj *unstructured.Unstructured) error {
+	rc, err := remotecluster.FromUnstructured(obj)
+	if err != nil {
+		return err
+	}
+	status := rc.Status
+	status.Conditions = append([]meta_v1.Condition{}, rc.Status.Conditions...)
+	status.ObservedGeneration = rc.Generation
+
+	m.lock.Lock()
+	running, ok := m.clusters[rc.Name]
+	paused := ok && running.paused
+	status.LastError = m.errors[rc.Name]
+	m.lock.Unlock()
+
+	var state health.ClusterState
+	for _, s := range health.States() {
+		if s.Name == rc.Name {
+			state = s
+		}
+	}
+	if status.LastError == "" {
+		status.LastError = state.Error
+	}
+	connected := meta_v1.Condition{Type: remotecluster.ConditionConnected, ObservedGeneration: rc.Generation}
+	synced := meta_v1.Condition{Type: remotecluster.ConditionSynced, ObservedGeneration: rc.Generation}
+	switch {
+	case paused:
+		connected.Status, connected.Reason = meta_v1.ConditionUnknown, "Paused"
+		synced.Status, synced.Reason = meta_v1.ConditionUnknown, "Paused"
+	case state.Connected:
+		connected.Status, connected.Reason = meta_v1.ConditionTrue, "Connected"
+		if state.Synced {
+			synced.Status, synced.Reason = meta_v1.ConditionTrue, "Synced"
+		} else {
+			synced.Status, synced.Reason = meta_v1.ConditionFalse, "Syncing"
+		}
+	default:
+		connected.Status, connected.Reason, connected.Message = meta_v1.ConditionFalse, "NotConnected", status.LastError
+		synced.Status, synced.Reason = meta_v1.ConditionFalse, "NotConnected"
+	}
+	meta.SetStatusCondition(&status.Conditions, connected)
+	meta.SetStatusCondition(&status.Conditions, synced)
+
+	if clusterStatus, ok := GetClusterStatus(rc.Name); ok {
+		if !clusterStatus.LastSyncTime.IsZero() {
+			status.LastSyncTime = &meta_v1.Time{Time: clusterStatus.LastSyncTime}
+		}
+		status.ObjectCounts = clusterStatus.ObjectCounts
+	}
+
+	if equality.Semantic.DeepEqual(status, rc.Status) {
+		return nil
+	}
+	if m.config.DryRun {
+		log.Debugf("dry-run: not updating status of remote cluster %s", rc.Name)
+		return nil
+	}
+	rc.Status = status
+	u, err := remotecluster.ToUnstructured(rc)
+	if err != nil {
+		return err
+	}
+	_, err = dynamicClient.Resource(remotecluster.Resource).Namespace(rc.Namespace).UpdateStatus(context.TODO(), u, meta_v1.UpdateOptions{})
+	return err
+}
+
+func remoteClusterSource(namespace string, name string) string {
+	return "RemoteCluster " + namespace + "/" + name
+}
@@ -0,0 +1,132 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package controller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	c "github.com/vmware/k8s-endpoints-sync-controller/src/config"
+	log "github.com/vmware/k8s-endpoints-sync-controller/src/log"
+	"k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	informercorev1 "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"net"
+	"strings"
+)
+
+// WatchClusterSecrets keeps a controller running for every Secret in
+// namespace matching selector. The kubeconfig of a cluster is read from the
+// CLUSTER_SECRET_KUBECONFIG_KEY key of its Secret, the cluster is named after
+// the Secret unless CLUSTER_SECRET_ANNOTATION_NAME_KEY says otherwise. The
+// client of a cluster is rebuilt whenever its kubeconfig changes, and the
+// cluster is removed along with its Secret.
+func (m *ClusterManager) WatchClusterSecrets(namespace string, selector string, stopCh <-chan struct{}) error {
+	client, err := getlocalkubeclient()
+	if err != nil {
+		return err
+	}
+	informer := informercorev1.NewFilteredSecretInformer(client, namespace, 0, cache.Indexers{}, func(options *meta_v1.ListOptions) {
+		options.LabelSelector = selector
+	})
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			m.syncSecret(obj.(*v1.Secret))
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			m.syncSecret(newObj.(*v1.Secret))
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if secret, ok := obj.(*v1.Secret); ok {
+				m.stopSource(secretSource(secret), "")
+			}
+		},
+	})
+	log.Infof("Watching secrets of remote clusters in namespace %s matching %s", namespace, selector)
+	go informer.Run(stopCh)
+	return nil
+}
+
+// syncSecret starts the cluster of secret, or restarts it if its kubeconfig,
+// pod CIDRs or name changed.
+func (m *ClusterManager) syncSecret(secret *v1.Secret) {
+	source := secretSource(secret)
+	kubeconfig, ok := secret.Data[c.CLUSTER_SECRET_KUBECONFIG_KEY]
+	if !ok {
+		log.Errorf("Secret %s has no %s key, ignoring it", source, c.CLUSTER_SECRET_KUBECONFIG_KEY)
+		m.stopSource(source, "")
+		return
+	}
+	name := secret.Name
+	if n := secret.Annotations[c.CLUSTER_SECRET_ANNOTATION_NAME_KEY]; n != "" {
+		name = n
+	}
+	podCIDRs := m.config.ClusterPodCIDRs[name]
+	if val, ok := secret.Annotations[c.CLUSTER_SECRET_ANNOTATION_PODCIDRS_KEY]; ok {
+		var err error
+		if podCIDRs, err = parseCIDRs(val); err != nil {
+			log.Errorf("Error parsing pod CIDRs of secret %s, ignoring it %v", source, err)
+			return
+		}
+	}
+
+	hash := sha256.New()
+	hash.Write(kubeconfig)
+	hash.Write([]byte(secret.Annotations[c.CLUSTER_SECRET_ANNOTATION_PODCIDRS_KEY]))
+	checksum := hash.Sum(nil)
+
+	m.stopSource(source, name)
+	m.lock.Lock()
+	running, ok := m.clusters[name]
+	m.lock.Unlock()
+	if ok && running.source == source && bytes.Equal(running.checksum, checksum) {
+		return
+	}
+	log.Infof("Credentials of cluster %s from secret %s changed", name, source)
+	m.start(c.ClusterConfig{
+		Name:             name,
+		Kubeconfig:       kubeconfig,
+		PodCIDRs:         podCIDRs,
+		NamespaceMapping: m.config.ClusterNamespaceMappings[name],
+	}, source, checksum)
+}
+
+// stopSource stops the clusters started from source, except the one named
+// keep.
+func (m *ClusterManager) stopSource(source string, keep string) {
+	m.lock.Lock()
+	var names []string
+	for name, running := range m.clusters {
+		if running.source == source && name != keep {
+			names = append(names, name)
+		}
+	}
+	m.lock.Unlock()
+	for _, name := range names {
+		log.Infof("Removing cluster %s of secret %s", name, source)
+		m.Stop(name)
+	}
+}
+
+func secretSource(secret *v1.Secret) string {
+	return secret.Namespace + "/" + secret.Name
+}
+
+func parseCIDRs(val string) ([]*net.IPNet, error) {
+	var cidrs []*net.IPNet
+	for _, cidr := range strings.Split(val, ",") {
+		if strings.TrimSpace(cidr) == "" {
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, err
+		}
+		cidrs = append(cidrs, ipnet)
+	}
+	return cidrs, nil
+}
@@ -0,0 +1,251 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	c "github.com/vmware/k8s-endpoints-sync-controller/src/config"
+	"github.com/vmware/k8s-endpoints-sync-controller/src/handlers"
+	log "github.com/vmware/k8s-endpoints-sync-controller/src/log"
+	"github.com/vmware/k8s-endpoints-sync-controller/src/servicereplication"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listercorev1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"sort"
+	"time"
+)
+
+// serviceReplicationPeriod is the interval at which the ServiceReplication
+// of every replicated service is updated.
+const serviceReplicationPeriod = 30 * time.Second
+
+// serviceReplicationListers reads the local services, endpoints and
+// ServiceReplications from the caches of shared informers.
+type serviceReplicationListers struct {
+	services     listercorev1.ServiceLister
+	endpoints    listercorev1.EndpointsLister
+	replications cache.GenericLister
+}
+
+// RunServiceReplications keeps a ServiceReplication next to every local
+// service that is replicated from or receives addresses of remote clusters,
+// and deletes those of services that no longer do. It returns once stopCh is
+// closed.
+func RunServiceReplications(config *c.Config, stopCh <-chan struct{}) error {
+	restConfig, err := getlocalrestconfig()
+	if err != nil {
+		return err
+	}
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		log.Errorf("Error creating dynamic client with inclusterConfig, %s", err)
+		return err
+	}
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		log.Errorf("Error creating client with inclusterConfig, %s", err)
+		return err
+	}
+
+	factory := informers.NewSharedInformerFactory(kubeClient, 0)
+	dynamicFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 0)
+	listers := serviceReplicationListers{
+		services:     factory.Core().V1().Services().Lister(),
+		endpoints:    factory.Core().V1().Endpoints().Lister(),
+		replications: dynamicFactory.ForResource(servicereplication.Resource).Lister(),
+	}
+	factory.Start(stopCh)
+	dynamicFactory.Start(stopCh)
+	log.Infof("Waiting for services, endpoints and service replications to be synced")
+	for informerType, synced := range factory.WaitForCacheSync(stopCh) {
+		if !synced {
+			return fmt.Errorf("failed to sync %v", informerType)
+		}
+	}
+	for resource, synced := range dynamicFactory.WaitForCacheSync(stopCh) {
+		if !synced {
+			return fmt.Errorf("failed to sync %v", resource)
+		}
+	}
+
+	wait.Until(func() {
+		if err := updateServiceReplications(listers, dynamicClient, config); err != nil {
+			log.Errorf("Error updating service replications %v", err)
+		}
+	}, serviceReplicationPeriod, stopCh)
+	return nil
+}
+
+// updateServiceReplications brings the ServiceReplications in line with the
+// local services. The objects of listers are shared and not modified.
+func updateServiceReplications(listers serviceReplicationListers, dynamicClient dynamic.Interface, config *c.Config) error {
+	services, err := listers.services.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	replicationList, err := listers.replications.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	existing := map[string]*servicereplication.ServiceReplication{}
+	for _, obj := range replicationList {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		replication, err := servicereplication.FromUnstructured(u.DeepCopy())
+		if err != nil {
+			log.Errorf("Error decoding service replication, err %v", err)
+			continue
+		}
+		existing[replication.Namespace+"/"+replication.Name] = replication
+	}
+
+	replicated := map[string]bool{}
+	syncKeys := map[string]bool{}
+	for _, service := range services {
+		key := service.Namespace + "/" + service.Name
+		endpoints, err := listers.endpoints.Endpoints(service.Namespace).Get(service.Name)
+		if err != nil && !apierrors.IsNotFound(err) {
+			log.Errorf("Error retrieving endpoints %s, err %v", key, err)
+			continue
+		}
+		if !replicatedService(config, service, endpoints) {
+			continue
+		}
+		replicated[key] = true
+		syncKeys[handlers.ServiceSyncKey(service)] = true
+		if err := applyServiceReplication(dynamicClient, config, service, endpoints, existing[key]); err != nil {
+			log.Errorf("Error updating service replication %s, err %v", key, err)
+		}
+	}
+	handlers.RetainServiceSyncs(syncKeys)
+
+	for key, replication := range existing {
+		if replicated[key] {
+			continue
+		}
+		if config.DryRun {
+			log.Debugf("dry-run: not deleting service replication %s", key)
+			continue
+		}
+		err := dynamicClient.Resource(servicereplication.Resource).Namespace(replication.Namespace).Delete(context.TODO(), replication.Name, meta_v1.DeleteOptions{})
+		if err != nil {
+			log.Errorf("Error deleting service replication %s, err %v", key, err)
+		}
+	}
+	return nil
+}
+
+// replicatedService reports whether service is a replica, has a syndicate
+// mode or receives addresses of remote clusters in its endpoints.
+func replicatedService(config *c.Config, service *v1.Service, endpoints *v1.Endpoints) bool {
+	return service.Labels[c.REPLICATED_LABEL_KEY] == config.ReplicatedLabelVal ||
+		service.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] != "" ||
+		len(handlers.EndpointSourceClusters(endpoints)) > 0
+}
+
+// applyServiceReplication creates the ServiceReplication of service or
+// updates existing if its status changed.
+func applyServiceReplication(dynamicClient dynamic.Interface, config *c.Config, service *v1.Service, endpoints *v1.Endpoints,
+	existing *servicereplication.ServiceReplication) error {
+	replication := existing
+	if replication == nil {
+		replication = &servicereplication.ServiceReplication{}
+		replication.APIVersion = servicereplication.Group + "/" + servicereplication.Version
+		replication.Kind = servicereplication.Kind
+		replication.Name = service.Name
+		replication.Namespace = service.Namespace
+		replication.OwnerReferences = []meta_v1.OwnerReference{*meta_v1.NewControllerRef(service, v1.SchemeGroupVersion.WithKind("Service"))}
+	}
+	status := serviceReplicationStatus(config, service, endpoints, replication.Status.Conditions)
+	if existing != nil && equality.Semantic.DeepEqual(status, existing.Status) {
+		return nil
+	}
+	if config.DryRun {
+		log.Debugf("dry-run: not updating service replication %s namespace %s", service.Name, service.Namespace)
+		return nil
+	}
+	replication.Status = status
+	u, err := servicereplication.ToUnstructured(replication)
+	if err != nil {
+		return err
+	}
+	resource := dynamicClient.Resource(servicereplication.Resource).Namespace(service.Namespace)
+	if existing == nil {
+		_, err = resource.Create(context.TODO(), u, meta_v1.CreateOptions{})
+		return err
+	}
+	_, err = resource.Update(context.TODO(), u, meta_v1.UpdateOptions{})
+	return err
+}
+
+// serviceReplicationStatus returns the current replication state of service,
+// updating a copy of conditions.
+func serviceReplicationStatus(config *c.Config, service *v1.Service, endpoints *v1.Endpoints, conditions []meta_v1.Condition) servicereplication.Status {
+	status := servicereplication.Status{
+		Mode:       service.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY],
+		Conditions: append([]meta_v1.Condition{}, conditions...),
+		Destination: servicereplication.Destination{
+			Replicated:     service.Labels[c.REPLICATED_LABEL_KEY] == config.ReplicatedLabelVal,
+			EndpointsExist: endpoints != nil,
+		},
+	}
+	if endpoints != nil {
+		for _, subset := range endpoints.Subsets {
+			status.Destination.Addresses += len(subset.Addresses)
+		}
+	}
+	sourceAddresses := handlers.EndpointSourceAddresses(endpoints)
+	if cluster, ok := service.Labels[c.EPS_LABEL_SOURCE_CLUSTER_KEY]; ok && len(sourceAddresses) == 0 {
+		// Per-cluster replicas hold only the addresses of their cluster.
+		sourceAddresses[cluster] = status.Destination.Addresses
+	}
+	remoteAddresses := 0
+	for cluster, addresses := range sourceAddresses {
+		status.SourceClusters = append(status.SourceClusters, servicereplication.SourceCluster{Name: cluster, Addresses: addresses})
+		remoteAddresses += addresses
+	}
+	sort.Slice(status.SourceClusters, func(i, j int) bool {
+		return status.SourceClusters[i].Name < status.SourceClusters[j].Name
+	})
+	if status.Mode == c.SVC_ANNOTATION_FAILOVER && endpoints != nil && endpoints.Annotations[c.EP_ANNOTATION_FAILOVER_KEY] != "true" {
+		// The addresses of the source clusters are kept aside while local
+		// addresses are ready.
+		status.Destination.LocalAddresses = status.Destination.Addresses
+	} else if status.Destination.Addresses > remoteAddresses {
+		status.Destination.LocalAddresses = status.Destination.Addresses - remoteAddresses
+	}
+
+	if serviceSync, ok := handlers.GetServiceSync(handlers.ServiceSyncKey(service)); ok {
+		if !serviceSync.LastSyncTime.IsZero() {
+			status.LastSyncTime = &meta_v1.Time{Time: serviceSync.LastSyncTime}
+		}
+		status.LastError = serviceSync.LastError
+	}
+
+	healthy := meta_v1.Condition{Type: servicereplication.ConditionHealthy}
+	switch {
+	case status.LastError != "":
+		healthy.Status, healthy.Reason, healthy.Message = meta_v1.ConditionFalse, "ReplicationFailed", status.LastError
+	case status.Destination.Addresses == 0:
+		healthy.Status, healthy.Reason = meta_v1.ConditionFalse, "NoAddresses"
+	default:
+		healthy.Status, healthy.Reason = meta_v1.ConditionTrue, "Healthy"
+	}
+	meta.SetStatusCondition(&status.Conditions, healthy)
+	return status
+}
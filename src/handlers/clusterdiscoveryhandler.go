@@ -4,28 +4,55 @@
 package handlers
 
 import (
+	"context"
 	c "github.com/vmware/k8s-endpoints-sync-controller/src/config"
 	"github.com/vmware/k8s-endpoints-sync-controller/src/log"
+	"github.com/vmware/k8s-endpoints-sync-controller/src/metrics"
 	"github.com/vmware/k8s-endpoints-sync-controller/src/utils"
 	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	"strings"
+	"sync"
 )
 
 type ClusterDiscoveryHandler struct {
-	kubeclient           *kubernetes.Clientset
+	restConfig           *rest.Config
+	kubeclient           kubernetes.Interface
+	recorder             record.EventRecorder
 	label                string
 	config               *c.Config
 	replicatedNamespaces *utils.ConcurrentMap
-	createHandler        HandlerFunc
-	updateHandler        HandlerFunc
+	syncHandler          HandlerFunc
 	deleteHandler        HandlerFunc
+
+	// exportedServices holds the cluster/namespace/name of the remote
+	// services being replicated, for their endpoints to follow them.
+	exportedServices *utils.ConcurrentMap
+
+	// namespaceLabels holds the labels of the remote namespaces seen so far,
+	// for the namespace rules applied to services and endpoints.
+	namespaceLabelsLock sync.RWMutex
+	namespaceLabels     map[string]map[string]string
+
+	// policies holds the valid ReplicationPolicies by namespace, sorted by
+	// name, and remoteServices the labels and ports of the remote services
+	// by the cluster, local namespace and name, for the policies of their
+	// endpoints.
+	policiesLock       sync.RWMutex
+	policies           map[string][]policy
+	remoteServicesLock sync.RWMutex
+	remoteServices     map[string]remoteService
 }
 
 type HandlerFunc struct {
-	handle func(obj interface{})
+	handle func(cluster string, obj interface{}) error
 }
 
 func (s *ClusterDiscoveryHandler) Init(conf *c.Config) error {
@@ -34,113 +61,270 @@ func (s *ClusterDiscoveryHandler) Init(conf *c.Config) error {
 		log.Errorf("Error fetching incluster config %s", configErr)
 		return configErr
 	}
+	config.Wrap(metrics.InstrumentTransport)
 	kubeclient, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		log.Errorf("Error creating client with inclusterConfig, %s", err)
 		return err
 	}
+	s.restConfig = config
 	s.kubeclient = kubeclient
+	s.recorder = newEventRecorder(kubeclient)
 	s.config = conf
 	s.replicatedNamespaces = utils.NewConcurrentMap()
-	s.prepareCreateHandler()
-	s.prepareUpdateHandler()
+	s.exportedServices = utils.NewConcurrentMap()
+	s.namespaceLabels = map[string]map[string]string{}
+	s.policies = map[string][]policy{}
+	s.remoteServices = map[string]remoteService{}
+	s.prepareSyncHandler()
 	s.prepareDeleteHandler()
 	return nil
 }
 
-func (s *ClusterDiscoveryHandler) prepareCreateHandler() {
-	s.createHandler = HandlerFunc{
-		handle: func(obj interface{}) {
+func (s *ClusterDiscoveryHandler) prepareSyncHandler() {
+	s.syncHandler = HandlerFunc{
+		handle: func(cluster string, obj interface{}) error {
 			switch v := obj.(type) {
 			case *v1.Namespace:
-				s.handleNamespaceCreate(v)
+				return s.handleNamespaceUpdate(v.DeepCopy())
 			case *v1.Endpoints:
-				s.handleEnpointCreateOrUpdate(v)
-			case *v1.Service:
-				s.handleServiceCreate(v, false)
-			}
-		},
-	}
-}
-
-func (s *ClusterDiscoveryHandler) prepareUpdateHandler() {
-	s.updateHandler = HandlerFunc{
-		handle: func(obj interface{}) {
-			switch v := obj.(type) {
-			case *v1.Namespace:
-				s.handleNamespaceUpdate(v)
-			case *v1.Endpoints:
-				s.handleEnpointCreateOrUpdate(v)
+				if err := s.handlePerClusterEndpoints(cluster, v.DeepCopy()); err != nil || !s.mergedNames(v.Annotations) {
+					return err
+				}
+				return s.handleEnpointCreateOrUpdate(cluster, v.DeepCopy())
+			case *discoveryv1.EndpointSlice:
+				if err := s.handlePerClusterEndpointSlice(cluster, v.DeepCopy()); err != nil || !s.mergedNames(v.Annotations) {
+					return err
+				}
+				return s.handleEndpointSliceCreateOrUpdate(cluster, v.DeepCopy())
 			case *v1.Service:
-				s.handleServiceUpdate(v)
+				if err := s.handlePerClusterService(cluster, v.DeepCopy()); err != nil || !s.mergedNames(v.Annotations) {
+					return err
+				}
+				return s.handleServiceUpdate(cluster, v.DeepCopy())
 			}
+			return nil
 		},
 	}
 }
 
 func (s *ClusterDiscoveryHandler) prepareDeleteHandler() {
 	s.deleteHandler = HandlerFunc{
-		handle: func(obj interface{}) {
+		handle: func(cluster string, obj interface{}) error {
 			switch v := obj.(type) {
 			case *v1.Namespace:
-				s.handleNamespaceDelete(v)
+				return s.handleNamespaceDelete(v.DeepCopy())
 			case *v1.Endpoints:
-				s.handleEnpointDelete(v)
+				if err := s.handlePerClusterEndpointsDelete(cluster, v.DeepCopy()); err != nil || !s.mergedReplica(v.Labels, v.Annotations) {
+					return err
+				}
+				return s.handleEnpointDelete(cluster, v.DeepCopy())
+			case *discoveryv1.EndpointSlice:
+				if err := s.handlePerClusterEndpointSliceDelete(cluster, v.DeepCopy()); err != nil || !s.mergedReplica(v.Labels, v.Annotations) {
+					return err
+				}
+				return s.handleEndpointSliceDelete(cluster, v.DeepCopy())
 			case *v1.Service:
-				s.handleServiceDelete(v)
+				if err := s.handlePerClusterServiceDelete(cluster, v.DeepCopy()); err != nil || !s.mergedReplica(v.Labels, v.Annotations) {
+					return err
+				}
+				return s.handleServiceDelete(cluster, v.DeepCopy())
 			}
+			return nil
 		},
 	}
 }
 
-func (s *ClusterDiscoveryHandler) ObjectCreated(obj interface{}) {
-	if s.shouldProcessEvent(obj) {
-		s.handleEvent(obj, s.createHandler)
+// ObjectSynced replicates obj of cluster. A service that was replicated but
+// no longer is, for instance because it is no longer exported, is handled as
+// deleted.
+func (s *ClusterDiscoveryHandler) ObjectSynced(cluster string, obj interface{}) error {
+	if s.shouldProcessEvent(cluster, obj) {
+		if svc, ok := obj.(*v1.Service); ok {
+			s.exportedServices.Store(serviceKey(cluster, svc.Namespace, svc.Name), true)
+			s.setRemoteService(cluster, svc)
+		}
+		return s.handleEvent(cluster, obj, s.syncHandler)
 	}
+	if svc, ok := obj.(*v1.Service); ok && s.exportedServices.Load(serviceKey(cluster, svc.Namespace, svc.Name)) {
+		log.Infof("service %s namespace %s of cluster %s is no longer replicated", svc.Name, svc.Namespace, cluster)
+		if err := s.handleEvent(cluster, obj, s.deleteHandler); err != nil {
+			return err
+		}
+		s.exportedServices.Delete(serviceKey(cluster, svc.Namespace, svc.Name))
+		s.deleteRemoteService(cluster, svc)
+	}
+	return nil
+}
+
+func serviceKey(cluster string, namespace string, name string) string {
+	return cluster + "/" + namespace + "/" + name
 }
 
-func (s *ClusterDiscoveryHandler) handleEvent(obj interface{}, handler HandlerFunc) {
-	handler.handle(obj)
+// handleEvent hands a copy of obj moved to its local namespace to handler,
+// with its replication policy applied. With ServiceReplications set the
+// outcome is recorded for the service obj belongs to.
+func (s *ClusterDiscoveryHandler) handleEvent(cluster string, obj interface{}, handler HandlerFunc) error {
+	local := s.toLocalNamespace(cluster, obj)
+	if s.config.ReplicationPolicies {
+		local = s.applyPolicy(cluster, local)
+	}
+	err := handler.handle(cluster, local)
+	if s.config.ServiceReplications {
+		recordServiceSync(cluster, local, err)
+	}
+	return err
 }
 
-func (s *ClusterDiscoveryHandler) ObjectDeleted(obj interface{}) {
-	if s.shouldProcessEvent(obj) {
-		s.handleEvent(obj, s.deleteHandler)
+// toLocalNamespace returns a copy of obj in the local namespace its
+// namespace in cluster is mapped to, or obj itself if it is not mapped.
+func (s *ClusterDiscoveryHandler) toLocalNamespace(cluster string, obj interface{}) interface{} {
+	switch v := obj.(type) {
+	case *v1.Namespace:
+		if local, _ := s.config.LocalNamespace(cluster, v.Name); local != v.Name {
+			v = v.DeepCopy()
+			v.Name = local
+			return v
+		}
+	case *v1.Endpoints:
+		if local, _ := s.config.LocalNamespace(cluster, v.Namespace); local != v.Namespace {
+			v = v.DeepCopy()
+			v.Namespace = local
+			return v
+		}
+	case *discoveryv1.EndpointSlice:
+		if local, _ := s.config.LocalNamespace(cluster, v.Namespace); local != v.Namespace {
+			v = v.DeepCopy()
+			v.Namespace = local
+			return v
+		}
+	case *v1.Service:
+		if local, _ := s.config.LocalNamespace(cluster, v.Namespace); local != v.Namespace {
+			v = v.DeepCopy()
+			v.Namespace = local
+			return v
+		}
 	}
+	return obj
 }
 
-func (s *ClusterDiscoveryHandler) ObjectUpdated(oldObj, newObj interface{}) {
-	if s.shouldProcessEvent(newObj) {
-		s.handleEvent(newObj, s.updateHandler)
+func (s *ClusterDiscoveryHandler) ObjectDeleted(cluster string, obj interface{}) error {
+	if s.shouldProcessEvent(cluster, obj) {
+		if err := s.handleEvent(cluster, obj, s.deleteHandler); err != nil {
+			return err
+		}
 	}
+	if svc, ok := obj.(*v1.Service); ok {
+		s.exportedServices.Delete(serviceKey(cluster, svc.Namespace, svc.Name))
+		s.deleteRemoteService(cluster, svc)
+	}
+	return nil
 }
 
-func (s *ClusterDiscoveryHandler) handleEnpointCreateOrUpdate(endpoints *v1.Endpoints) {
-	log.Debugf("updating endpoints %s namespace %s", endpoints.Name, endpoints.Namespace)
+func (s *ClusterDiscoveryHandler) handleEnpointCreateOrUpdate(cluster string, endpoints *v1.Endpoints) error {
+	log.Debugf("updating endpoints %s namespace %s from cluster %s", endpoints.Name, endpoints.Namespace, cluster)
 	/*b, _ := json.MarshalIndent(endpoints, "", "  ")
 	fmt.Println("In endpoint before update :", string(b))*/
 	var endpointsToApply v1.Endpoints
-	clusterCIDR := ""
 	syndicate_ep := false
 	if strings.HasSuffix(endpoints.Name, "-syndicate") || strings.HasSuffix(endpoints.SelfLink, "-syndicate") {
 		endpoints.Name = strings.TrimSuffix(endpoints.Name, "-syndicate")
 		syndicate_ep = true
 	}
 	endpointsToApply.Name = endpoints.Name
+	endpointsToApply.Namespace = endpoints.Namespace
 	endpointsToApply.Labels = endpoints.Labels
 	if endpointsToApply.Labels == nil {
 		endpointsToApply.Labels = map[string]string{}
 	}
 	endpointsToApply.Labels[c.REPLICATED_LABEL_KEY] = s.config.ReplicatedLabelVal
 
+	remoteSubsets := s.getClusterSubsets(cluster, endpoints)
+	mode := s.getSyndicateMode(endpoints.Namespace, endpoints.Name)
+	if mode == c.SVC_ANNOTATION_SINGULAR {
+		return nil
+	}
+	unionSvcEndpoint := mode == c.SVC_ANNOTATION_UNION
+	failoverSvcEndpoint := mode == c.SVC_ANNOTATION_FAILOVER
+	existingEndpoints, err := s.kubeclient.CoreV1().Endpoints(endpoints.Namespace).Get(context.TODO(), endpoints.Name, meta_v1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Errorf("Error retrieving endpoints obj, err %s", err)
+		return err
+	}
+	sources := getEndpointSources(existingEndpoints)
+	var localSubsets []v1.EndpointSubset
+	if (syndicate_ep || unionSvcEndpoint) && existingEndpoints != nil && existingEndpoints.Name != "" {
+		localSubsets = s.getLocalSubsets(existingEndpoints, sources)
+	}
+	if len(remoteSubsets) > 0 {
+		sources[cluster] = remoteSubsets
+	} else {
+		delete(sources, cluster)
+	}
+	if failoverSvcEndpoint {
+		endpointsToApply.Subsets = s.failoverSubsets(&endpointsToApply, sources)
+	} else {
+		endpointsToApply.Subsets = sources.subsets(localSubsets)
+	}
+	if err := setEndpointSources(&endpointsToApply, sources); err != nil {
+		log.Errorf("Error recording sources of endpoint %s", err)
+		return err
+	}
+
+	if existingEndpoints != nil && existingEndpoints.Name == "" {
+		if eErr := s.createEndpoints(cluster, &endpointsToApply); eErr != nil {
+			log.Errorf("Error creating endpoint %s", eErr)
+			return eErr
+		}
+	} else {
+		if !syndicate_ep && unionSvcEndpoint {
+			if !s.changeInEndpoints(existingEndpoints, &endpointsToApply) &&
+				existingEndpoints.Annotations[c.EP_ANNOTATION_SOURCES_KEY] == endpointsToApply.Annotations[c.EP_ANNOTATION_SOURCES_KEY] {
+				log.Infof("No change in endpoints %s namespace %s", existingEndpoints.Name, existingEndpoints.Namespace)
+				return nil
+			}
+		}
+		if failoverSvcEndpoint {
+			if !s.changeInEndpoints(existingEndpoints, &endpointsToApply) &&
+				existingEndpoints.Annotations[c.EP_ANNOTATION_SOURCES_KEY] == endpointsToApply.Annotations[c.EP_ANNOTATION_SOURCES_KEY] &&
+				existingEndpoints.Annotations[c.EP_ANNOTATION_FAILOVER_KEY] == endpointsToApply.Annotations[c.EP_ANNOTATION_FAILOVER_KEY] {
+				log.Infof("No change in endpoints %s namespace %s", existingEndpoints.Name, existingEndpoints.Namespace)
+				return nil
+			}
+		}
+		if !unionSvcEndpoint && !failoverSvcEndpoint {
+			if !s.changeInEndpoints(existingEndpoints, &endpointsToApply) &&
+				equality.Semantic.DeepEqual(existingEndpoints.Labels, endpointsToApply.Labels) &&
+				equality.Semantic.DeepEqual(existingEndpoints.Annotations, endpointsToApply.Annotations) {
+				log.Infof("No change in endpoints %s namespace %s", existingEndpoints.Name, existingEndpoints.Namespace)
+				return nil
+			}
+		}
+		if unionSvcEndpoint || failoverSvcEndpoint {
+			endpointsToApply.Labels[c.REPLICATED_LABEL_KEY] = "false"
+		}
+		endpointsToApply.ResourceVersion = existingEndpoints.ResourceVersion
+		if eErr := s.updateEndpoints(cluster, &endpointsToApply); eErr != nil {
+			log.Errorf("Error updating endpoint %s", eErr)
+			return eErr
+		}
+	}
+	if failoverSvcEndpoint {
+		s.recordFailover(existingEndpoints, &endpointsToApply)
+	}
+	return nil
+}
+
+// getClusterSubsets returns the addresses of endpoints that belong to the
+// pods of cluster.
+func (s *ClusterDiscoveryHandler) getClusterSubsets(cluster string, endpoints *v1.Endpoints) []v1.EndpointSubset {
+	var clusterSubsets []v1.EndpointSubset
 	for _, v := range endpoints.Subsets {
 		var endpointset v1.EndpointSubset
 		for _, address := range v.Addresses {
-			if address.IP != "" {
-				if clusterCIDR == "" {
-					clusterCIDR = address.IP[0:6]
-				}
-				endpointAddress := v1.EndpointAddress{IP: address.IP}
+			ip := utils.NormalizeIP(address.IP)
+			if ip != "" && s.isClusterAddress(cluster, ip) {
+				endpointAddress := v1.EndpointAddress{IP: ip}
 				if address.Hostname != "" {
 					endpointAddress.Hostname = address.Hostname
 				}
@@ -152,62 +336,59 @@ func (s *ClusterDiscoveryHandler) handleEnpointCreateOrUpdate(endpoints *v1.Endp
 				endpointPort := v1.EndpointPort{Name: port.Name, Port: port.Port, Protocol: port.Protocol}
 				endpointset.Ports = append(endpointset.Ports, endpointPort)
 			}
-			endpointsToApply.Subsets = append(endpointsToApply.Subsets, endpointset)
+			clusterSubsets = append(clusterSubsets, endpointset)
 		}
 	}
-	unionSvcEndpoint, singularSvcEndpoint := s.checkIfUnionorSingularSvcEndpoint(endpoints)
-	if singularSvcEndpoint {
-		return
-	}
-	existingEndpoints, _ := s.kubeclient.CoreV1().Endpoints(endpoints.Namespace).Get(endpoints.Name, meta_v1.GetOptions{})
-	if existingEndpoints != nil && existingEndpoints.Name == "" {
-		if _, eErr := s.kubeclient.CoreV1().Endpoints(endpoints.Namespace).Create(&endpointsToApply); eErr != nil {
-			log.Errorf("Error creating endpoint %s", eErr)
-			return
-		}
-	} else {
-		if !syndicate_ep && unionSvcEndpoint {
-			if !s.changeInEndpoints(existingEndpoints, &endpointsToApply) {
-				log.Infof("No change in endpoints %s namespace %s", existingEndpoints.Name, existingEndpoints.Namespace)
-				return
+	return clusterSubsets
+}
+
+// getLocalSubsets returns the addresses of existingEndpoints that belong to
+// the pods of the local cluster.
+func (s *ClusterDiscoveryHandler) getLocalSubsets(existingEndpoints *v1.Endpoints, sources endpointSources) []v1.EndpointSubset {
+	var localSubsets []v1.EndpointSubset
+	for _, v := range existingEndpoints.Subsets {
+		var endpointset v1.EndpointSubset
+		for _, address := range v.Addresses {
+			if !s.isLocalAddress(address.IP, sources) {
+				continue
 			}
-		} else if syndicate_ep {
-			for _, v := range existingEndpoints.Subsets {
-				var endpointset v1.EndpointSubset
-				for _, address := range v.Addresses {
-					if clusterCIDR != "" {
-						if !strings.HasPrefix(address.IP, clusterCIDR) {
-							endpointAddress := v1.EndpointAddress{IP: address.IP}
-							if address.Hostname != "" {
-								endpointAddress.Hostname = address.Hostname
-							}
-							endpointset.Addresses = append(endpointset.Addresses, endpointAddress)
-						}
-					} else {
-						endpointAddress := v1.EndpointAddress{IP: address.IP}
-						if address.Hostname != "" {
-							endpointAddress.Hostname = address.Hostname
-						}
-						endpointset.Addresses = append(endpointset.Addresses, endpointAddress)
-					}
-				}
-				if len(endpointset.Addresses) > 0 {
-					for _, port := range v.Ports {
-						endpointPort := v1.EndpointPort{Name: port.Name, Port: port.Port, Protocol: port.Protocol}
-						endpointset.Ports = append(endpointset.Ports, endpointPort)
-					}
-					endpointsToApply.Subsets = append(endpointsToApply.Subsets, endpointset)
-				}
+			endpointAddress := v1.EndpointAddress{IP: address.IP}
+			if address.Hostname != "" {
+				endpointAddress.Hostname = address.Hostname
 			}
+			endpointset.Addresses = append(endpointset.Addresses, endpointAddress)
 		}
-		if unionSvcEndpoint {
-			endpointsToApply.Labels[c.REPLICATED_LABEL_KEY] = "false"
-		}
-		if _, eErr := s.kubeclient.CoreV1().Endpoints(endpoints.Namespace).Update(&endpointsToApply); eErr != nil {
-			log.Errorf("Error updating endpoint %s", eErr)
-			return
+		if len(endpointset.Addresses) > 0 {
+			for _, port := range v.Ports {
+				endpointPort := v1.EndpointPort{Name: port.Name, Port: port.Port, Protocol: port.Protocol}
+				endpointset.Ports = append(endpointset.Ports, endpointPort)
+			}
+			localSubsets = append(localSubsets, endpointset)
 		}
 	}
+	return localSubsets
+}
+
+// isClusterAddress reports whether ip, reported by cluster, belongs to the
+// pods of that cluster rather than being relayed from another cluster.
+func (s *ClusterDiscoveryHandler) isClusterAddress(cluster string, ip string) bool {
+	if owner, ok := s.config.ClusterForIP(ip); ok {
+		return owner == cluster
+	}
+	return !s.config.IsLocalIP(ip)
+}
+
+// isLocalAddress reports whether ip belongs to the pods of the local cluster.
+// Without configured local pod CIDRs, any address not claimed by a remote
+// cluster is considered local.
+func (s *ClusterDiscoveryHandler) isLocalAddress(ip string, sources endpointSources) bool {
+	if len(s.config.LocalPodCIDRs) > 0 {
+		return s.config.IsLocalIP(ip)
+	}
+	if _, ok := s.config.ClusterForIP(ip); ok {
+		return false
+	}
+	return !sources.owns(ip)
 }
 
 func (s *ClusterDiscoveryHandler) changeInEndpoints(existingEndpoints *v1.Endpoints, endpointsToApply *v1.Endpoints) bool {
@@ -230,15 +411,19 @@ func (s *ClusterDiscoveryHandler) changeInEndpoints(existingEndpoints *v1.Endpoi
 	return count != len(ipmap)
 }
 
-func (s *ClusterDiscoveryHandler) handleServiceCreate(svc *v1.Service, syndicate_svc bool) {
+func (s *ClusterDiscoveryHandler) handleServiceCreate(cluster string, svc *v1.Service, syndicate_svc bool) error {
 	log.Infof("creating service %s, namespace %s", svc.Name, svc.Namespace)
 	if syndicate_svc {
 		svc.Name = svc.Name + "-syndicate"
 	}
-	existingService, _ := s.kubeclient.CoreV1().Services(svc.Namespace).Get(svc.Name, meta_v1.GetOptions{})
+	existingService, err := s.kubeclient.CoreV1().Services(svc.Namespace).Get(context.TODO(), svc.Name, meta_v1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Errorf("Error retrieving service obj, err %s", err)
+		return err
+	}
 	if existingService != nil && existingService.Name == "" {
 		if svc.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] == c.SVC_ANNOTATION_SINGULAR {
-			return
+			return nil
 		}
 		service := v1.Service{}
 		service.Name = svc.Name
@@ -257,11 +442,13 @@ func (s *ClusterDiscoveryHandler) handleServiceCreate(svc *v1.Service, syndicate
 		for _, port := range svc.Spec.Ports {
 			service.Spec.Ports = append(service.Spec.Ports, v1.ServicePort{Protocol: port.Protocol, Name: port.Name, Port: port.Port, TargetPort: port.TargetPort})
 		}
-		if _, err := s.kubeclient.CoreV1().Services(svc.Namespace).Create(&service); err != nil {
+		setIPFamilies(&service, svc)
+		if err := s.createService(cluster, &service); err != nil {
 			log.Errorf("Error creating service %s", err)
-			return
+			return err
 		}
 	} else {
+		originalService := existingService.DeepCopy()
 		existingService.Spec.Ports = []v1.ServicePort{}
 		for _, port := range svc.Spec.Ports {
 			existingService.Spec.Ports = append(existingService.Spec.Ports, v1.ServicePort{Protocol: port.Protocol, Name: port.Name, Port: port.Port, TargetPort: port.TargetPort})
@@ -273,248 +460,232 @@ func (s *ClusterDiscoveryHandler) handleServiceCreate(svc *v1.Service, syndicate
 		if svc.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] == c.SVC_ANNOTATION_SINGULAR {
 			if existingService.Labels[c.REPLICATED_LABEL_KEY] == "true" &&
 				existingService.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] != c.SVC_ANNOTATION_SINGULAR {
-				s.handleServiceDelete(existingService)
+				s.recordModeChange(cluster, existingService, c.SVC_ANNOTATION_SINGULAR)
+				return s.handleServiceDelete(cluster, existingService)
 			}
-			return
+			return nil
 		}
 		existingService.Labels[c.REPLICATED_LABEL_KEY] = s.config.ReplicatedLabelVal
-		if _, err := s.kubeclient.CoreV1().Services(svc.Namespace).Update(existingService); err != nil {
-			log.Errorf("Error updating service %s", err)
-			return
-		}
+		setIPFamilies(existingService, svc)
+		return s.updateChangedService(cluster, originalService, existingService)
 	}
+	return nil
 }
 
-func (s *ClusterDiscoveryHandler) handleServiceUpdate(service *v1.Service) {
-	log.Infof("updating service %s namespace %s", service.Name, service.Namespace)
-
-	existingService, err := s.kubeclient.CoreV1().Services(service.Namespace).Get(service.Name, meta_v1.GetOptions{})
-	if err != nil {
-		log.Errorf("Error retrieving service obj, err %s", err)
+// setIPFamilies copies the IP family settings of svc onto service, so that
+// replicas of IPv6 and dual-stack services get the same families. The primary
+// family of an existing service cannot change, in that case service keeps its
+// families.
+func setIPFamilies(service *v1.Service, svc *v1.Service) {
+	if len(service.Spec.IPFamilies) > 0 && len(svc.Spec.IPFamilies) > 0 && service.Spec.IPFamilies[0] != svc.Spec.IPFamilies[0] {
+		log.Errorf("Cannot change primary ip family of service %s namespace %s from %s to %s",
+			service.Name, service.Namespace, service.Spec.IPFamilies[0], svc.Spec.IPFamilies[0])
 		return
 	}
-	if service.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] == c.SVC_ANNOTATION_SINGULAR {
-		if existingService.Labels[c.REPLICATED_LABEL_KEY] == "true" &&
-			existingService.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] != c.SVC_ANNOTATION_SINGULAR {
-			s.handleServiceDelete(existingService)
-		}
-		return
+	service.Spec.IPFamilyPolicy = svc.Spec.IPFamilyPolicy
+	service.Spec.IPFamilies = svc.Spec.IPFamilies
+	if len(svc.Spec.IPFamilies) > 0 && len(service.Spec.ClusterIPs) > len(svc.Spec.IPFamilies) {
+		service.Spec.ClusterIPs = service.Spec.ClusterIPs[:len(svc.Spec.IPFamilies)]
 	}
-	if service.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] == c.SVC_ANNOTATION_UNION {
+}
 
-		if existingService.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] != c.SVC_ANNOTATION_UNION {
-			if existingService.Annotations == nil {
-				existingService.Annotations = map[string]string{}
-			}
-			existingService.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] = c.SVC_ANNOTATION_UNION
-			if existingService.Labels == nil {
-				existingService.Labels = map[string]string{}
-			}
-			existingService.Labels[c.REPLICATED_LABEL_KEY] = "false"
+// handleServiceUpdate brings the local copy of service of cluster to the
+// syndicate mode service requests, resuming the transition that was
+// interrupted first, see migration.go.
+func (s *ClusterDiscoveryHandler) handleServiceUpdate(cluster string, service *v1.Service) error {
+	log.Infof("updating service %s namespace %s", service.Name, service.Namespace)
 
-		} else if existingService.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] == c.SVC_ANNOTATION_UNION {
-			if existingService.Labels == nil {
-				existingService.Labels = map[string]string{}
-			}
-			existingService.Labels[c.REPLICATED_LABEL_KEY] = "true"
-		}
-		existingEndpoints, _ := s.kubeclient.CoreV1().Endpoints(service.Namespace).Get(service.Name, meta_v1.GetOptions{})
-		if existingEndpoints.Labels == nil {
-			existingEndpoints.Labels = map[string]string{}
-		}
-		existingEndpoints.Labels[c.REPLICATED_LABEL_KEY] = "false"
-		existingEndpoints.ResourceVersion = ""
-		if _, err := s.kubeclient.CoreV1().Endpoints(service.Namespace).Update(existingEndpoints); err != nil {
-			log.Errorf("Error updating endpoints %s", err)
-			return
+	existingService, err := s.kubeclient.CoreV1().Services(service.Namespace).Get(context.TODO(), service.Name, meta_v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return s.handleServiceCreate(cluster, service, false)
+	}
+	if err != nil {
+		log.Errorf("Error retrieving service obj, err %s", err)
+		return err
+	}
+	if phase, ok := existingService.Annotations[c.SVC_ANNOTATION_PHASE_KEY]; ok {
+		existingService, err = s.resumeTransition(cluster, service, existingService, phase)
+		if err == errPending {
+			return nil
 		}
-		s.handleServiceCreate(service, true)
-		existingService.Spec.Selector = nil
-		if _, err := s.kubeclient.CoreV1().Services(service.Namespace).Update(existingService); err != nil {
-			log.Errorf("Error updating service %s", err)
-			return
+		if err != nil {
+			return err
 		}
-		return
 	}
-
-	if service.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] == c.SVC_ANNOTATION_SOURCE {
-		if existingService.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] != c.SVC_ANNOTATION_RECEIVER {
-			existingEndpoints, err := s.kubeclient.CoreV1().Endpoints(service.Namespace).Get(service.Name, meta_v1.GetOptions{})
-			if err != nil {
-				log.Errorf("Error retrieving endpoints obj, err %s", err)
-				return
-			}
-			if existingEndpoints.Labels == nil {
-				existingEndpoints.Labels = map[string]string{}
-			}
-			existingEndpoints.Labels[c.REPLICATED_LABEL_KEY] = "false"
-			existingEndpoints.ResourceVersion = ""
-			if _, eErr := s.kubeclient.CoreV1().Endpoints(service.Namespace).Update(existingEndpoints); eErr != nil {
-				log.Errorf("Error updating endpoint %s", eErr)
-				return
-			}
-			if existingService.Labels == nil {
-				existingService.Labels = map[string]string{}
-			}
-			existingService.Labels[c.REPLICATED_LABEL_KEY] = "false"
-			if existingService.Annotations == nil {
-				existingService.Annotations = map[string]string{}
-			}
-			existingService.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] = c.SVC_ANNOTATION_RECEIVER
-			if _, err := s.kubeclient.CoreV1().Services(service.Namespace).Update(existingService); err != nil {
-				log.Errorf("Error updating service %s", err)
-				return
-			}
-			service.Name = service.Name + "-syndicate"
-			s.handleServiceDelete(service)
-			return
-		} else if existingService.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] == c.SVC_ANNOTATION_RECEIVER {
-			existingEndpoints, err := s.kubeclient.CoreV1().Endpoints(service.Namespace).Get(service.Name, meta_v1.GetOptions{})
-			if err != nil {
-				log.Errorf("Error retrieving endpoints obj, err %s", err)
-				return
-			}
-			if existingEndpoints.Labels == nil {
-				existingEndpoints.Labels = map[string]string{}
-			}
-			existingEndpoints.Labels[c.REPLICATED_LABEL_KEY] = "true"
-			existingEndpoints.ResourceVersion = ""
-			if _, eErr := s.kubeclient.CoreV1().Endpoints(service.Namespace).Update(existingEndpoints); eErr != nil {
-				log.Errorf("Error updating endpoint %s", eErr)
-				return
-			}
-			if existingService.Labels == nil {
-				existingService.Labels = map[string]string{}
-			}
-			existingService.Labels = service.Labels
-			existingService.Labels[c.REPLICATED_LABEL_KEY] = "true"
-			existingService.Spec.Selector = nil
-			if _, err := s.kubeclient.CoreV1().Services(service.Namespace).Update(existingService); err != nil {
-				log.Errorf("Error updating service %s", err)
-				return
-			}
-			return
-		}
+	transition, err := syndicateTransitionFor(existingService, service)
+	if err == nil && s.config.WatchEndpointSlices {
+		err = sliceModeSupported(service.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY])
 	}
+	if err != nil {
+		log.Errorf("Not changing service %s namespace %s, %v", service.Name, service.Namespace, err)
+		s.recordEvent(cluster, existingService, v1.EventTypeWarning, eventReasonInvalidTransition, err.Error())
+		return nil
+	}
+	if transition == nil {
+		return nil
+	}
+	if err := s.runTransition(cluster, service, existingService, transition, 0); err != errPending {
+		return err
+	}
+	return nil
+}
 
-	if service.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] == c.SVC_ANNOTATION_RECEIVER {
-
-		if existingService.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] != c.SVC_ANNOTATION_SOURCE {
+func (s *ClusterDiscoveryHandler) handleEnpointDelete(cluster string, endpoints *v1.Endpoints) error {
+	log.Infof("deleting endpoints %s namespace %s from cluster %s", endpoints.Name, endpoints.Namespace, cluster)
+	syndicate_ep := false
+	if strings.HasSuffix(endpoints.Name, "-syndicate") {
+		endpoints.Name = strings.TrimSuffix(endpoints.Name, "-syndicate")
+		syndicate_ep = true
+	}
+	existingService, err := s.kubeclient.CoreV1().Services(endpoints.Namespace).Get(context.TODO(), endpoints.Name, meta_v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		log.Errorf("Error retrieving service obj, err %s", err)
+		return err
+	}
+	if existingService.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] == c.SVC_ANNOTATION_SINGULAR {
+		return nil
+	}
 
-			existingEndpoints, err := s.kubeclient.CoreV1().Endpoints(service.Namespace).Get(service.Name, meta_v1.GetOptions{})
-			if err != nil {
-				log.Errorf("Error retrieving endpoints obj, err %s", err)
-				return
-			}
-			if existingEndpoints.Labels == nil {
-				existingEndpoints.Labels = map[string]string{}
-			}
-			existingEndpoints.Labels[c.REPLICATED_LABEL_KEY] = "false"
-			existingEndpoints.ResourceVersion = ""
-			if _, eErr := s.kubeclient.CoreV1().Endpoints(service.Namespace).Update(existingEndpoints); eErr != nil {
-				log.Errorf("Error updating endpoint %s", eErr)
-				return
-			}
-			if existingService.Labels == nil {
-				existingService.Labels = map[string]string{}
-			}
-			existingService.Labels[c.REPLICATED_LABEL_KEY] = "false"
-			if existingService.Annotations == nil {
-				existingService.Annotations = map[string]string{}
-			}
-			existingService.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] = c.SVC_ANNOTATION_SOURCE
-			if _, err := s.kubeclient.CoreV1().Services(service.Namespace).Update(existingService); err != nil {
-				log.Errorf("Error updating service %s", err)
-				return
-			}
-			service.Name = service.Name + "-syndicate"
-			s.handleServiceDelete(service)
-			return
+	existingEndpoints, err := s.kubeclient.CoreV1().Endpoints(endpoints.Namespace).Get(context.TODO(), endpoints.Name, meta_v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		log.Errorf("Error retrieving endpoints obj, err %s", err)
+		return err
+	}
+	failover := existingService.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] == c.SVC_ANNOTATION_FAILOVER
+	return s.removeEndpointSource(cluster, existingEndpoints, syndicate_ep || failover)
+}
 
+// removeEndpointSource drops the addresses contributed by cluster from
+// existingEndpoints. The object is deleted once no cluster contributes to it,
+// unless it also holds local addresses.
+func (s *ClusterDiscoveryHandler) removeEndpointSource(cluster string, existingEndpoints *v1.Endpoints, keepLocal bool) error {
+	sources := getEndpointSources(existingEndpoints)
+	var localSubsets []v1.EndpointSubset
+	var previous *v1.Endpoints
+	if keepLocal {
+		localSubsets = s.getLocalSubsets(existingEndpoints, sources)
+		if s.getSyndicateMode(existingEndpoints.Namespace, existingEndpoints.Name) == c.SVC_ANNOTATION_FAILOVER {
+			previous = existingEndpoints.DeepCopy()
 		}
+	}
+	delete(sources, cluster)
 
-		SelectorForSvc := s.getSelectorfromSyndicateSvc(service)
-		service.Name = service.Name + "-syndicate"
-		s.handleServiceDelete(service)
-		if SelectorForSvc != nil {
-			existingService.Spec.Selector = SelectorForSvc
-		}
-		if existingService.Labels == nil {
-			existingService.Labels = map[string]string{}
-		}
-		existingService.Labels[c.REPLICATED_LABEL_KEY] = "false"
-		if _, err := s.kubeclient.CoreV1().Services(service.Namespace).Update(existingService); err != nil {
-			log.Errorf("Error updating service %s", err)
-			return
-		}
-		existingEndpoints, err := s.kubeclient.CoreV1().Endpoints(service.Namespace).Get(service.Name, meta_v1.GetOptions{})
-		if err != nil {
-			log.Errorf("Error retrieving endpoints obj, err %s", err)
-			return
+	if len(sources) == 0 && !keepLocal {
+		if eErr := s.deleteEndpoints(cluster, existingEndpoints.Namespace, existingEndpoints.Name); eErr != nil {
+			log.Errorf("Error deleting endpoint %s", eErr)
+			return eErr
 		}
-		if existingEndpoints.Labels == nil {
-			existingEndpoints.Labels = map[string]string{}
-		}
-		existingEndpoints.Labels[c.REPLICATED_LABEL_KEY] = "false"
-		existingEndpoints.ResourceVersion = ""
-		if _, eErr := s.kubeclient.CoreV1().Endpoints(service.Namespace).Update(existingEndpoints); eErr != nil {
-			log.Errorf("Error updating endpoint %s", eErr)
-			return
-		}
-		return
+		return nil
 	}
-
-	if existingService != nil && existingService.Name == "" {
-		s.handleServiceCreate(service, false)
-		return
+	if previous != nil {
+		existingEndpoints.Subsets = s.failoverSubsets(existingEndpoints, sources)
+	} else {
+		existingEndpoints.Subsets = sources.subsets(localSubsets)
 	}
-
-	existingService.Spec.Ports = []v1.ServicePort{}
-	for _, port := range service.Spec.Ports {
-		existingService.Spec.Ports = append(existingService.Spec.Ports, v1.ServicePort{Protocol: port.Protocol, Name: port.Name, Port: port.Port, TargetPort: port.TargetPort})
+	if err := setEndpointSources(existingEndpoints, sources); err != nil {
+		log.Errorf("Error recording sources of endpoint %s", err)
+		return err
 	}
-	existingService.Labels = service.Labels
-	if existingService.Labels == nil {
-		existingService.Labels = map[string]string{}
+	if eErr := s.updateEndpoints(cluster, existingEndpoints); eErr != nil {
+		log.Errorf("Error updating endpoint %s", eErr)
+		return eErr
 	}
-	existingService.Labels[c.REPLICATED_LABEL_KEY] = s.config.ReplicatedLabelVal
-	if _, err := s.kubeclient.CoreV1().Services(service.Namespace).Update(existingService); err != nil {
-		log.Errorf("Error updating service %s", err)
-		return
+	if previous != nil {
+		s.recordFailover(previous, existingEndpoints)
 	}
+	return nil
 }
 
-func (s *ClusterDiscoveryHandler) handleEnpointDelete(endpoints *v1.Endpoints) {
-	log.Infof("deleting endpoints %s namespace %s", endpoints.Name, endpoints.Namespace)
-	existingService, err := s.kubeclient.CoreV1().Services(endpoints.Namespace).Get(endpoints.Name, meta_v1.GetOptions{})
+// RemoveCluster drops the addresses contributed by cluster from every local
+// Endpoints object and deletes the endpointslices and per-cluster replicas
+// replicated from it, leaving the addresses of all other clusters in place.
+func (s *ClusterDiscoveryHandler) RemoveCluster(cluster string) error {
+	log.Infof("removing endpoints of cluster %s", cluster)
+	if s.config.WatchEndpointSlices {
+		if err := s.removeClusterEndpointSlices(cluster); err != nil {
+			return err
+		}
+	}
+	if err := s.removeClusterPerClusterReplicas(cluster); err != nil {
+		return err
+	}
+	endpointsList, err := s.kubeclient.CoreV1().Endpoints(v1.NamespaceAll).List(context.TODO(), meta_v1.ListOptions{})
 	if err != nil {
-		log.Errorf("Error retrieving service obj, err %s", err)
-		return
+		log.Errorf("Error listing endpoints, err %s", err)
+		return err
 	}
-	if existingService.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] == c.SVC_ANNOTATION_SINGULAR {
-		return
+	for i := range endpointsList.Items {
+		endpoints := &endpointsList.Items[i]
+		if _, ok := getEndpointSources(endpoints)[cluster]; !ok {
+			continue
+		}
+		keepLocal := endpoints.Labels[c.REPLICATED_LABEL_KEY] != s.config.ReplicatedLabelVal
+		if err := s.removeEndpointSource(cluster, endpoints, keepLocal); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	if eErr := s.kubeclient.CoreV1().Endpoints(endpoints.Namespace).Delete(endpoints.Name, &meta_v1.DeleteOptions{}); eErr != nil {
-		log.Errorf("Error deleting endpoint %s", eErr)
-		return
+// DeleteReplica deletes the local replica obj. Per-cluster replicas are
+// attributed to the cluster in their source cluster label.
+func (s *ClusterDiscoveryHandler) DeleteReplica(obj interface{}) error {
+	switch v := obj.(type) {
+	case *v1.Namespace:
+		return s.handleNamespaceDelete(v.DeepCopy())
+	case *v1.Service:
+		log.Infof("deleting service %s namespace %s", v.Name, v.Namespace)
+		return s.deleteService(v.Labels[c.EPS_LABEL_SOURCE_CLUSTER_KEY], v.Namespace, v.Name)
+	case *v1.Endpoints:
+		log.Infof("deleting endpoints %s namespace %s", v.Name, v.Namespace)
+		return s.deleteEndpoints(v.Labels[c.EPS_LABEL_SOURCE_CLUSTER_KEY], v.Namespace, v.Name)
+	case *discoveryv1.EndpointSlice:
+		log.Infof("deleting endpointslice %s namespace %s", v.Name, v.Namespace)
+		return s.deleteEndpointSlice(v.Namespace, v.Name)
 	}
+	return nil
 }
 
-func (s *ClusterDiscoveryHandler) handleServiceDelete(service *v1.Service) {
+// RemoveEndpointsSource drops the addresses contributed by cluster from the
+// local endpoints.
+func (s *ClusterDiscoveryHandler) RemoveEndpointsSource(cluster string, endpoints *v1.Endpoints) error {
+	existingEndpoints, err := s.kubeclient.CoreV1().Endpoints(endpoints.Namespace).Get(context.TODO(), endpoints.Name, meta_v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		log.Errorf("Error retrieving endpoints obj, err %s", err)
+		return err
+	}
+	keepLocal := existingEndpoints.Labels[c.REPLICATED_LABEL_KEY] != s.config.ReplicatedLabelVal
+	return s.removeEndpointSource(cluster, existingEndpoints, keepLocal)
+}
+
+func (s *ClusterDiscoveryHandler) handleServiceDelete(cluster string, service *v1.Service) error {
 	log.Infof("deleting service %s namespace %s", service.Name, service.Namespace)
 	if service.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] == c.SVC_ANNOTATION_SINGULAR {
-		return
+		return nil
 	}
-	if eErr := s.kubeclient.CoreV1().Services(service.Namespace).Delete(service.Name, &meta_v1.DeleteOptions{}); eErr != nil {
+	if eErr := s.deleteService(cluster, service.Namespace, service.Name); eErr != nil {
 		log.Errorf("Error deleting service %v", eErr)
-		return
+		return eErr
 	}
+	return nil
 }
 
-func (s *ClusterDiscoveryHandler) handleNamespaceCreate(n *v1.Namespace) {
+func (s *ClusterDiscoveryHandler) handleNamespaceCreate(n *v1.Namespace) error {
 	log.Infof("creating namespace %s", n.Name)
-	existingNamespace, _ := s.kubeclient.CoreV1().Namespaces().Get(n.Name, meta_v1.GetOptions{})
+	existingNamespace, err := s.kubeclient.CoreV1().Namespaces().Get(context.TODO(), n.Name, meta_v1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Errorf("Error retrieving namespace obj, err %v", err)
+		return err
+	}
 
 	if existingNamespace != nil && existingNamespace.Name == "" {
 		ns := v1.Namespace{}
@@ -524,9 +695,9 @@ func (s *ClusterDiscoveryHandler) handleNamespaceCreate(n *v1.Namespace) {
 			ns.Labels = map[string]string{}
 		}
 		ns.Labels[c.REPLICATED_LABEL_KEY] = s.config.ReplicatedLabelVal
-		if _, err := s.kubeclient.CoreV1().Namespaces().Create(&ns); err != nil {
+		if err := s.createNamespace(&ns); err != nil {
 			log.Errorf("Error creating namespace %v", err)
-			return
+			return err
 		}
 	} else {
 		existingNamespace.Labels = n.Labels
@@ -538,25 +709,29 @@ func (s *ClusterDiscoveryHandler) handleNamespaceCreate(n *v1.Namespace) {
 		} else {
 			existingNamespace.Labels[c.REPLICATED_LABEL_KEY] = s.config.ReplicatedLabelVal
 		}
-		if _, err := s.kubeclient.CoreV1().Namespaces().Update(existingNamespace); err != nil {
+		if err := s.updateNamespace(existingNamespace); err != nil {
 			log.Errorf("Error updating namespace %v", err)
-			return
+			return err
 		}
 	}
 	s.replicatedNamespaces.Store(n.Name, true)
+	return nil
 }
 
-func (s *ClusterDiscoveryHandler) handleNamespaceUpdate(n *v1.Namespace) {
+func (s *ClusterDiscoveryHandler) handleNamespaceUpdate(n *v1.Namespace) error {
 	log.Infof("updating namespace %s", n.Name)
 
 	if s.replicatedNamespaces.Load(n.Name) {
-		return
+		return nil
 	}
 
-	existingNamespace, _ := s.kubeclient.CoreV1().Namespaces().Get(n.Name, meta_v1.GetOptions{})
+	existingNamespace, err := s.kubeclient.CoreV1().Namespaces().Get(context.TODO(), n.Name, meta_v1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Errorf("Error retrieving namespace obj, err %v", err)
+		return err
+	}
 	if existingNamespace != nil && existingNamespace.Name == "" {
-		s.handleNamespaceCreate(n)
-		return
+		return s.handleNamespaceCreate(n)
 	}
 
 	existingNamespace.Labels = n.Labels
@@ -568,21 +743,26 @@ func (s *ClusterDiscoveryHandler) handleNamespaceUpdate(n *v1.Namespace) {
 	} else {
 		existingNamespace.Labels[c.REPLICATED_LABEL_KEY] = s.config.ReplicatedLabelVal
 	}
-	if _, err := s.kubeclient.CoreV1().Namespaces().Update(existingNamespace); err != nil {
+	if err := s.updateNamespace(existingNamespace); err != nil {
 		log.Errorf("Error updating namespace %v", err)
-		return
+		return err
 	}
 	s.replicatedNamespaces.Store(n.Name, true)
+	return nil
 }
 
-func (s *ClusterDiscoveryHandler) handleNamespaceDelete(n *v1.Namespace) {
+func (s *ClusterDiscoveryHandler) handleNamespaceDelete(n *v1.Namespace) error {
 
 	log.Infof("deleting namespace %s", n.Name)
-	if err := s.kubeclient.CoreV1().Namespaces().Delete(n.Name, &meta_v1.DeleteOptions{}); err != nil {
+	if err := s.deleteNamespace(n.Name); err != nil {
 		log.Errorf("Error deleting namespace %v", err)
-		return
+		return err
 	}
 	s.replicatedNamespaces.Delete(n.Name)
+	s.namespaceLabelsLock.Lock()
+	delete(s.namespaceLabels, n.Name)
+	s.namespaceLabelsLock.Unlock()
+	return nil
 }
 
 func (s *ClusterDiscoveryHandler) checkIfReplicatedNamespace(namespace string, labels map[string]string) bool {
@@ -597,7 +777,7 @@ func (s *ClusterDiscoveryHandler) checkIfReplicatedNamespace(namespace string, l
 }
 
 func (s *ClusterDiscoveryHandler) getSelectorfromSyndicateSvc(service *v1.Service) map[string]string {
-	existingService, err := s.kubeclient.CoreV1().Services(service.Namespace).Get(service.Name+"-syndicate", meta_v1.GetOptions{})
+	existingService, err := s.kubeclient.CoreV1().Services(service.Namespace).Get(context.TODO(), service.Name+"-syndicate", meta_v1.GetOptions{})
 	if err != nil {
 		log.Errorf("Error retrieving service obj, err %v", err)
 		return nil
@@ -605,25 +785,44 @@ func (s *ClusterDiscoveryHandler) getSelectorfromSyndicateSvc(service *v1.Servic
 	return existingService.Spec.Selector
 }
 
-func (s *ClusterDiscoveryHandler) checkIfUnionorSingularSvcEndpoint(endpoints *v1.Endpoints) (bool, bool) {
-	existingService, err := s.kubeclient.CoreV1().Services(endpoints.Namespace).Get(endpoints.Name, meta_v1.GetOptions{})
+// getSyndicateMode returns the syndicate mode of the local service name.
+func (s *ClusterDiscoveryHandler) getSyndicateMode(namespace string, name string) string {
+	existingService, err := s.kubeclient.CoreV1().Services(namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
 	if err != nil {
 		log.Errorf("Error retrieving service obj, err %v", err)
-		return false, false
+		return ""
 	}
-	return existingService.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] == c.SVC_ANNOTATION_UNION,
-		existingService.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] == c.SVC_ANNOTATION_SINGULAR
+	return existingService.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY]
 }
 
-func (s *ClusterDiscoveryHandler) shouldProcessEvent(obj interface{}) bool {
+// shouldProcessEvent reports whether obj of cluster is replicated. The
+// namespace rules apply to the namespaces of cluster, replicated namespaces
+// are tracked by their local name.
+func (s *ClusterDiscoveryHandler) shouldProcessEvent(cluster string, obj interface{}) bool {
 	switch v := obj.(type) {
 	case *v1.Namespace:
-		if s.checkIfReplicatedNamespace(v.Name, v.Labels) || utils.ContainsInArray(s.config.NamespacesToExclude, v.Name) || !utils.CanReplicateNamespace(v.Labels) {
+		local, ok := s.config.LocalNamespace(cluster, v.Name)
+		if !ok {
+			log.Errorf("Ignoring namespace %s of cluster %s, another namespace is mapped to it", v.Name, cluster)
 			return false
 		}
-		return true
+		if s.checkIfReplicatedNamespace(local, v.Labels) || !utils.CanReplicateNamespace(v.Labels) {
+			return false
+		}
+		s.namespaceLabelsLock.Lock()
+		s.namespaceLabels[local] = v.Labels
+		s.namespaceLabelsLock.Unlock()
+		return s.namespaceIncluded(cluster, v.Name)
 	case *v1.Endpoints:
-		if utils.ContainsKeyVal(v.Labels, s.config.ReplicatedLabelVal) || !s.replicatedNamespaces.Load(v.Namespace) || v.Name == c.KUBERNETES {
+		if utils.ContainsKeyVal(v.Labels, s.config.ReplicatedLabelVal) || !s.replicatedNamespace(cluster, v.Namespace) || v.Name == c.KUBERNETES ||
+			!s.namespaceIncluded(cluster, v.Namespace) || !s.serviceExported(cluster, v.Namespace, strings.TrimSuffix(v.Name, "-syndicate")) {
+			return false
+		}
+		return true
+	case *discoveryv1.EndpointSlice:
+		if utils.ContainsKeyVal(v.Labels, s.config.ReplicatedLabelVal) || v.Labels[discoveryv1.LabelManagedBy] == c.EPS_LABEL_MANAGED_BY_VAL ||
+			!s.replicatedNamespace(cluster, v.Namespace) || v.Labels[discoveryv1.LabelServiceName] == "" || v.Labels[discoveryv1.LabelServiceName] == c.KUBERNETES ||
+			!s.namespaceIncluded(cluster, v.Namespace) || !s.serviceExported(cluster, v.Namespace, v.Labels[discoveryv1.LabelServiceName]) {
 			return false
 		}
 		return true
@@ -631,10 +830,67 @@ func (s *ClusterDiscoveryHandler) shouldProcessEvent(obj interface{}) bool {
 		if strings.HasSuffix(v.Name, "-syndicate") {
 			return false
 		}
-		if utils.ContainsKeyVal(v.Labels, s.config.ReplicatedLabelVal) || !s.replicatedNamespaces.Load(v.Namespace) || v.Name == c.KUBERNETES {
+		if utils.ContainsKeyVal(v.Labels, s.config.ReplicatedLabelVal) || !s.replicatedNamespace(cluster, v.Namespace) || v.Name == c.KUBERNETES ||
+			!s.namespaceIncluded(cluster, v.Namespace) || !s.config.ServiceExported(v.Labels, v.Annotations) || !s.policyDestination(cluster, v) {
 			return false
 		}
 		return true
 	}
 	return false
 }
+
+// serviceExported reports whether the endpoints of the service named name in
+// namespace of cluster are replicated. With opt-in export or replication
+// policies they only are once the service itself has been replicated.
+func (s *ClusterDiscoveryHandler) serviceExported(cluster string, namespace string, name string) bool {
+	return (!s.config.ExportOptIn && !s.config.ReplicationPolicies) || s.exportedServices.Load(serviceKey(cluster, namespace, name))
+}
+
+// replicatedNamespace reports whether the local namespace that namespace of
+// cluster is mapped to has been replicated.
+func (s *ClusterDiscoveryHandler) replicatedNamespace(cluster string, namespace string) bool {
+	local, ok := s.config.LocalNamespace(cluster, namespace)
+	return ok && s.replicatedNamespaces.Load(local)
+}
+
+// namespaceIncluded applies the namespace rules to the namespace named name
+// of cluster, using the labels it was last seen with.
+func (s *ClusterDiscoveryHandler) namespaceIncluded(cluster string, name string) bool {
+	local, _ := s.config.LocalNamespace(cluster, name)
+	s.namespaceLabelsLock.RLock()
+	nsLabels := s.namespaceLabels[local]
+	s.namespaceLabelsLock.RUnlock()
+	return s.config.NamespaceIncluded(name, nsLabels)
+}
+
+// RecordReplicatedObjects updates the gauges counting the namespaces,
+// services and endpoints replicated into the local cluster. The lists are
+// served from the watch cache of the API server rather than from etcd.
+func (s *ClusterDiscoveryHandler) RecordReplicatedObjects() {
+	options := meta_v1.ListOptions{
+		LabelSelector:   labels.Set{c.REPLICATED_LABEL_KEY: s.config.ReplicatedLabelVal}.AsSelector().String(),
+		ResourceVersion: "0",
+	}
+	if namespaces, err := s.kubeclient.CoreV1().Namespaces().List(context.TODO(), options); err != nil {
+		log.Errorf("Error listing replicated namespaces, err %v", err)
+	} else {
+		metrics.ReplicatedObjects.WithLabelValues("namespaces").Set(float64(len(namespaces.Items)))
+	}
+	if services, err := s.kubeclient.CoreV1().Services(v1.NamespaceAll).List(context.TODO(), options); err != nil {
+		log.Errorf("Error listing replicated services, err %v", err)
+	} else {
+		metrics.ReplicatedObjects.WithLabelValues("services").Set(float64(len(services.Items)))
+	}
+	if endpoints, err := s.kubeclient.CoreV1().Endpoints(v1.NamespaceAll).List(context.TODO(), options); err != nil {
+		log.Errorf("Error listing replicated endpoints, err %v", err)
+	} else {
+		metrics.ReplicatedObjects.WithLabelValues("endpoints").Set(float64(len(endpoints.Items)))
+	}
+	if s.config.WatchEndpointSlices {
+		if slices, err := s.kubeclient.DiscoveryV1().EndpointSlices(v1.NamespaceAll).List(context.TODO(), options); err != nil {
+			log.Errorf("Error listing replicated endpointslices, err %v", err)
+		} else {
+			metrics.ReplicatedObjects.WithLabelValues("endpointslices").Set(float64(len(slices.Items)))
+		}
+	}
+}
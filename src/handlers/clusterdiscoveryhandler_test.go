@@ -0,0 +1,137 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package handlers
+
+import (
+	c "github.com/vmware/k8s-endpoints-sync-controller/src/config"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"net"
+	"testing"
+)
+
+func parseCIDRs(t *testing.T, cidrs ...string) []*net.IPNet {
+	t.Helper()
+	var parsed []*net.IPNet
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("ParseCIDR(%q) error = %v", cidr, err)
+		}
+		parsed = append(parsed, ipNet)
+	}
+	return parsed
+}
+
+func TestGetClusterSubsets(t *testing.T) {
+	s := &ClusterDiscoveryHandler{config: &c.Config{
+		ClustersToWatch: []c.ClusterConfig{
+			{Name: "cluster-a", PodCIDRs: parseCIDRs(t, "10.1.0.0/16", "fd00:1::/64")},
+			{Name: "cluster-b", PodCIDRs: parseCIDRs(t, "10.2.0.0/16", "fd00:2::/64")},
+		},
+		LocalPodCIDRs: parseCIDRs(t, "10.0.0.0/16", "fd00::/64"),
+	}}
+	tests := []struct {
+		name    string
+		subsets []v1.EndpointSubset
+		want    []v1.EndpointSubset
+	}{
+		{
+			name: "ipv4",
+			subsets: []v1.EndpointSubset{
+				{Addresses: addresses("10.1.0.1", "10.1.0.2"), Ports: httpPorts},
+			},
+			want: []v1.EndpointSubset{
+				{Addresses: addresses("10.1.0.1", "10.1.0.2"), Ports: httpPorts},
+			},
+		},
+		{
+			name: "ipv6 normalized",
+			subsets: []v1.EndpointSubset{
+				{Addresses: addresses("FD00:1:0:0::1", "fd00:1::2"), Ports: httpPorts},
+			},
+			want: []v1.EndpointSubset{
+				{Addresses: addresses("fd00:1::1", "fd00:1::2"), Ports: httpPorts},
+			},
+		},
+		{
+			name: "dual-stack",
+			subsets: []v1.EndpointSubset{
+				{Addresses: addresses("10.1.0.1", "fd00:1::1", "::ffff:10.1.0.2"), Ports: httpPorts},
+			},
+			want: []v1.EndpointSubset{
+				{Addresses: addresses("10.1.0.1", "fd00:1::1", "10.1.0.2"), Ports: httpPorts},
+			},
+		},
+		{
+			name: "dual-stack with relayed addresses",
+			subsets: []v1.EndpointSubset{
+				{Addresses: addresses("10.1.0.1", "fd00:2::1", "10.0.0.1", "fd00::1", "fd00:1::1"), Ports: httpPorts},
+				{Addresses: addresses("10.2.0.1", "fd00:2::2"), Ports: dnsPorts},
+			},
+			want: []v1.EndpointSubset{
+				{Addresses: addresses("10.1.0.1", "fd00:1::1"), Ports: httpPorts},
+			},
+		},
+		{
+			name: "unclaimed addresses",
+			subsets: []v1.EndpointSubset{
+				{Addresses: addresses("2001:db8::1", "192.168.0.1", "pod-a"), Ports: httpPorts},
+			},
+			want: []v1.EndpointSubset{
+				{Addresses: addresses("2001:db8::1", "192.168.0.1"), Ports: httpPorts},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoints := &v1.Endpoints{Subsets: tt.subsets}
+			if got := s.getClusterSubsets("cluster-a", endpoints); !equality.Semantic.DeepEqual(got, tt.want) {
+				t.Errorf("getClusterSubsets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleEndpointsSkipsNoChange(t *testing.T) {
+	service := &v1.Service{ObjectMeta: meta_v1.ObjectMeta{Name: "api", Namespace: "payments"}}
+	client := fake.NewSimpleClientset(service)
+	s := &ClusterDiscoveryHandler{kubeclient: client, config: &c.Config{ReplicatedLabelVal: "true"}}
+	remote := func(ips ...string) *v1.Endpoints {
+		return &v1.Endpoints{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "api", Namespace: "payments", Labels: map[string]string{"app": "api"}},
+			Subsets:    []v1.EndpointSubset{{Addresses: addresses(ips...), Ports: httpPorts}},
+		}
+	}
+	updates := func() int {
+		count := 0
+		for _, action := range client.Actions() {
+			if action.GetVerb() == "update" && action.GetResource().Resource == "endpoints" {
+				count++
+			}
+		}
+		return count
+	}
+
+	tests := []struct {
+		name        string
+		endpoints   *v1.Endpoints
+		wantUpdates int
+	}{
+		{name: "create", endpoints: remote("10.1.0.1"), wantUpdates: 0},
+		{name: "resync", endpoints: remote("10.1.0.1"), wantUpdates: 0},
+		{name: "address added", endpoints: remote("10.1.0.1", "10.1.0.2"), wantUpdates: 1},
+		{name: "resync after update", endpoints: remote("10.1.0.1", "10.1.0.2"), wantUpdates: 1},
+	}
+	for _, tt := range tests {
+		if err := s.handleEnpointCreateOrUpdate("cluster-a", tt.endpoints); err != nil {
+			t.Fatalf("%s: handleEnpointCreateOrUpdate() error = %v", tt.name, err)
+		}
+		if got := updates(); got != tt.wantUpdates {
+			t.Errorf("%s: endpoints updated %d times, want %d", tt.name, got, tt.wantUpdates)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	c "github.com/vmware/k8s-endpoints-sync-controller/src/config"
+	"github.com/vmware/k8s-endpoints-sync-controller/src/log"
+	"github.com/vmware/k8s-endpoints-sync-controller/src/utils"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// endpointSliceReplicaName returns the name of the replica of slice of cluster
+// with naming: the slice and cluster names followed by a hash of all three, as
+// the names alone do not tell where one ends and the other starts.
+func endpointSliceReplicaName(naming string, cluster string, slice *discoveryv1.EndpointSlice) string {
+	hash := sha256.Sum256([]byte(naming + "/" + cluster + "/" + slice.Name))
+	return slice.Name + "-" + cluster + "-" + hex.EncodeToString(hash[:4])
+}
+
+// localEndpointSliceName returns the name of the local copy of slice
+// replicated from cluster.
+func localEndpointSliceName(cluster string, slice *discoveryv1.EndpointSlice) string {
+	return endpointSliceReplicaName(c.SERVICE_NAMING_MERGED, cluster, slice)
+}
+
+func (s *ClusterDiscoveryHandler) handleEndpointSliceCreateOrUpdate(cluster string, slice *discoveryv1.EndpointSlice) error {
+	serviceName := slice.Labels[discoveryv1.LabelServiceName]
+	log.Debugf("updating endpointslice %s of service %s namespace %s from cluster %s", slice.Name, serviceName, slice.Namespace, cluster)
+
+	existingService, err := s.kubeclient.CoreV1().Services(slice.Namespace).Get(context.TODO(), serviceName, meta_v1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		// The service is replicated on its own, its slices do not wait for it.
+	case err != nil:
+		log.Errorf("Error retrieving service obj, err %v", err)
+		return err
+	case existingService.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] == c.SVC_ANNOTATION_SINGULAR:
+		return nil
+	}
+
+	sliceToApply := s.getClusterEndpointSlice(cluster, slice, localEndpointSliceName(cluster, slice), serviceName)
+	return s.applyEndpointSlice(sliceToApply)
+}
+
+// sliceModeSupported returns an error for the syndicate modes other than
+// singular. They move the local pods between Endpoints objects, which has no
+// counterpart for endpointslices.
+func sliceModeSupported(mode string) error {
+	switch mode {
+	case "", c.SVC_ANNOTATION_SINGULAR:
+		return nil
+	}
+	return fmt.Errorf("mode %s is not supported with endpointslices", mode)
+}
+
+// getClusterEndpointSlice returns the local copy named name of slice of
+// cluster, holding the addresses of the pods of cluster for the local service
+// named serviceName.
+func (s *ClusterDiscoveryHandler) getClusterEndpointSlice(cluster string, slice *discoveryv1.EndpointSlice, name string, serviceName string) *discoveryv1.EndpointSlice {
+	sliceToApply := &discoveryv1.EndpointSlice{}
+	sliceToApply.Name = name
+	sliceToApply.Namespace = slice.Namespace
+	sliceToApply.Labels = map[string]string{
+		discoveryv1.LabelServiceName:   serviceName,
+		discoveryv1.LabelManagedBy:     c.EPS_LABEL_MANAGED_BY_VAL,
+		c.EPS_LABEL_SOURCE_CLUSTER_KEY: cluster,
+		c.REPLICATED_LABEL_KEY:         s.config.ReplicatedLabelVal,
+	}
+	sliceToApply.Annotations = map[string]string{
+		c.EPS_ANNOTATION_SOURCE_NAME_KEY: slice.Name,
+	}
+	sliceToApply.AddressType = slice.AddressType
+	for _, port := range slice.Ports {
+		sliceToApply.Ports = append(sliceToApply.Ports, discoveryv1.EndpointPort{
+			Name:        port.Name,
+			Protocol:    port.Protocol,
+			Port:        port.Port,
+			AppProtocol: port.AppProtocol,
+		})
+	}
+	for _, endpoint := range slice.Endpoints {
+		var addresses []string
+		for _, address := range endpoint.Addresses {
+			if slice.AddressType == discoveryv1.AddressTypeFQDN {
+				addresses = append(addresses, address)
+				continue
+			}
+			ip := utils.NormalizeIP(address)
+			if ip != "" && s.isClusterAddress(cluster, ip) {
+				addresses = append(addresses, ip)
+			}
+		}
+		if len(addresses) == 0 {
+			continue
+		}
+		// TargetRef and NodeName refer to objects of the remote cluster and
+		// are dropped, conditions and topology hints are kept as is.
+		sliceToApply.Endpoints = append(sliceToApply.Endpoints, discoveryv1.Endpoint{
+			Addresses:  addresses,
+			Conditions: endpoint.Conditions,
+			Hostname:   endpoint.Hostname,
+			Zone:       endpoint.Zone,
+			Hints:      endpoint.Hints,
+		})
+	}
+	return sliceToApply
+}
+
+// applyEndpointSlice creates sliceToApply or replaces the existing one.
+func (s *ClusterDiscoveryHandler) applyEndpointSlice(sliceToApply *discoveryv1.EndpointSlice) error {
+	existingSlice, err := s.kubeclient.DiscoveryV1().EndpointSlices(sliceToApply.Namespace).Get(context.TODO(), sliceToApply.Name, meta_v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if eErr := s.createEndpointSlice(sliceToApply); eErr != nil {
+			log.Errorf("Error creating endpointslice %s", eErr)
+			return eErr
+		}
+		return nil
+	}
+	if err != nil {
+		log.Errorf("Error retrieving endpointslice obj, err %s", err)
+		return err
+	}
+	sliceToApply.ResourceVersion = existingSlice.ResourceVersion
+	if eErr := s.updateEndpointSlice(sliceToApply); eErr != nil {
+		log.Errorf("Error updating endpointslice %s", eErr)
+		return eErr
+	}
+	return nil
+}
+
+func (s *ClusterDiscoveryHandler) handleEndpointSliceDelete(cluster string, slice *discoveryv1.EndpointSlice) error {
+	name := localEndpointSliceName(cluster, slice)
+	log.Infof("deleting endpointslice %s namespace %s from cluster %s", name, slice.Namespace, cluster)
+	if eErr := s.deleteEndpointSlice(slice.Namespace, name); eErr != nil {
+		log.Errorf("Error deleting endpointslice %s", eErr)
+		return eErr
+	}
+	return nil
+}
+
+// removeClusterEndpointSlices deletes every local endpointslice replicated
+// from cluster.
+func (s *ClusterDiscoveryHandler) removeClusterEndpointSlices(cluster string) error {
+	selector := labels.Set{
+		discoveryv1.LabelManagedBy:     c.EPS_LABEL_MANAGED_BY_VAL,
+		c.EPS_LABEL_SOURCE_CLUSTER_KEY: cluster,
+	}.AsSelector().String()
+	sliceList, err := s.kubeclient.DiscoveryV1().EndpointSlices(meta_v1.NamespaceAll).List(context.TODO(), meta_v1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		log.Errorf("Error listing endpointslices, err %s", err)
+		return err
+	}
+	for _, slice := range sliceList.Items {
+		if eErr := s.deleteEndpointSlice(slice.Namespace, slice.Name); eErr != nil {
+			log.Errorf("Error deleting endpointslice %s", eErr)
+			return eErr
+		}
+	}
+	return nil
+}
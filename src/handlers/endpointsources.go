@@ -0,0 +1,137 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	c "github.com/vmware/k8s-endpoints-sync-controller/src/config"
+	"github.com/vmware/k8s-endpoints-sync-controller/src/log"
+	v1 "k8s.io/api/core/v1"
+	"sort"
+	"strings"
+)
+
+// endpointSources maps the name of a remote cluster to the endpoint subsets
+// it contributed to a local Endpoints object. It is persisted as JSON in the
+// EP_ANNOTATION_SOURCES_KEY annotation of that object.
+type endpointSources map[string][]v1.EndpointSubset
+
+func getEndpointSources(endpoints *v1.Endpoints) endpointSources {
+	sources := endpointSources{}
+	if endpoints == nil {
+		return sources
+	}
+	val, ok := endpoints.Annotations[c.EP_ANNOTATION_SOURCES_KEY]
+	if !ok || val == "" {
+		return sources
+	}
+	if err := json.Unmarshal([]byte(val), &sources); err != nil {
+		log.Errorf("Error parsing sources of endpoints %s namespace %s, err %v", endpoints.Name, endpoints.Namespace, err)
+		return endpointSources{}
+	}
+	return sources
+}
+
+func setEndpointSources(endpoints *v1.Endpoints, sources endpointSources) error {
+	if len(sources) == 0 {
+		delete(endpoints.Annotations, c.EP_ANNOTATION_SOURCES_KEY)
+		return nil
+	}
+	b, err := json.Marshal(sources)
+	if err != nil {
+		return err
+	}
+	if endpoints.Annotations == nil {
+		endpoints.Annotations = map[string]string{}
+	}
+	endpoints.Annotations[c.EP_ANNOTATION_SOURCES_KEY] = string(b)
+	return nil
+}
+
+// clusters returns the names of the contributing clusters in a stable order.
+func (sources endpointSources) clusters() []string {
+	clusters := make([]string, 0, len(sources))
+	for cluster := range sources {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+	return clusters
+}
+
+// EndpointSourceClusters returns the names of the remote clusters that
+// contributed addresses to endpoints.
+func EndpointSourceClusters(endpoints *v1.Endpoints) []string {
+	return getEndpointSources(endpoints).clusters()
+}
+
+// EndpointSourceAddresses returns the number of addresses contributed to
+// endpoints by every remote cluster.
+func EndpointSourceAddresses(endpoints *v1.Endpoints) map[string]int {
+	addresses := map[string]int{}
+	for cluster, subsets := range getEndpointSources(endpoints) {
+		for _, subset := range subsets {
+			addresses[cluster] += len(subset.Addresses)
+		}
+	}
+	return addresses
+}
+
+// owns reports whether ip was contributed by any of the clusters.
+func (sources endpointSources) owns(ip string) bool {
+	for _, subsets := range sources {
+		for _, subset := range subsets {
+			for _, address := range subset.Addresses {
+				if address.IP == ip {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// subsets returns the subsets of all contributing clusters merged with extra.
+func (sources endpointSources) subsets(extra []v1.EndpointSubset) []v1.EndpointSubset {
+	all := append([]v1.EndpointSubset{}, extra...)
+	for _, cluster := range sources.clusters() {
+		all = append(all, sources[cluster]...)
+	}
+	return mergeSubsets(all)
+}
+
+// mergeSubsets groups addresses of subsets exposing the same ports together
+// and drops addresses that appear more than once for the same ports.
+func mergeSubsets(subsets []v1.EndpointSubset) []v1.EndpointSubset {
+	var merged []v1.EndpointSubset
+	index := map[string]int{}
+	seen := map[string]map[string]bool{}
+	for _, subset := range subsets {
+		key := portsKey(subset.Ports)
+		i, ok := index[key]
+		if !ok {
+			i = len(merged)
+			index[key] = i
+			seen[key] = map[string]bool{}
+			merged = append(merged, v1.EndpointSubset{Ports: subset.Ports})
+		}
+		for _, address := range subset.Addresses {
+			if seen[key][address.IP] {
+				continue
+			}
+			seen[key][address.IP] = true
+			merged[i].Addresses = append(merged[i].Addresses, address)
+		}
+	}
+	return merged
+}
+
+func portsKey(ports []v1.EndpointPort) string {
+	keys := make([]string, 0, len(ports))
+	for _, port := range ports {
+		keys = append(keys, fmt.Sprintf("%s/%d/%s", port.Name, port.Port, port.Protocol))
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
@@ -0,0 +1,134 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package handlers
+
+import (
+	c "github.com/vmware/k8s-endpoints-sync-controller/src/config"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"reflect"
+	"testing"
+)
+
+func addresses(ips ...string) []v1.EndpointAddress {
+	var list []v1.EndpointAddress
+	for _, ip := range ips {
+		list = append(list, v1.EndpointAddress{IP: ip})
+	}
+	return list
+}
+
+var (
+	httpPorts = []v1.EndpointPort{{Name: "http", Port: 80, Protocol: v1.ProtocolTCP}}
+	dnsPorts  = []v1.EndpointPort{
+		{Name: "dns", Port: 53, Protocol: v1.ProtocolUDP},
+		{Name: "dns-tcp", Port: 53, Protocol: v1.ProtocolTCP},
+	}
+	dnsPortsReordered = []v1.EndpointPort{dnsPorts[1], dnsPorts[0]}
+)
+
+func TestMergeSubsets(t *testing.T) {
+	tests := []struct {
+		name    string
+		subsets []v1.EndpointSubset
+		want    []v1.EndpointSubset
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "same ports merged",
+			subsets: []v1.EndpointSubset{
+				{Addresses: addresses("10.0.0.1"), Ports: httpPorts},
+				{Addresses: addresses("10.0.1.1"), Ports: httpPorts},
+			},
+			want: []v1.EndpointSubset{
+				{Addresses: addresses("10.0.0.1", "10.0.1.1"), Ports: httpPorts},
+			},
+		},
+		{
+			name: "duplicate addresses dropped",
+			subsets: []v1.EndpointSubset{
+				{Addresses: addresses("10.0.0.1", "10.0.0.2"), Ports: httpPorts},
+				{Addresses: addresses("10.0.0.2", "10.0.0.3"), Ports: httpPorts},
+			},
+			want: []v1.EndpointSubset{
+				{Addresses: addresses("10.0.0.1", "10.0.0.2", "10.0.0.3"), Ports: httpPorts},
+			},
+		},
+		{
+			name: "port order ignored",
+			subsets: []v1.EndpointSubset{
+				{Addresses: addresses("10.0.0.1"), Ports: dnsPorts},
+				{Addresses: addresses("10.0.1.1"), Ports: dnsPortsReordered},
+			},
+			want: []v1.EndpointSubset{
+				{Addresses: addresses("10.0.0.1", "10.0.1.1"), Ports: dnsPorts},
+			},
+		},
+		{
+			name: "different ports kept apart",
+			subsets: []v1.EndpointSubset{
+				{Addresses: addresses("10.0.0.1"), Ports: httpPorts},
+				{Addresses: addresses("10.0.0.1"), Ports: dnsPorts},
+			},
+			want: []v1.EndpointSubset{
+				{Addresses: addresses("10.0.0.1"), Ports: httpPorts},
+				{Addresses: addresses("10.0.0.1"), Ports: dnsPorts},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeSubsets(tt.subsets); !equality.Semantic.DeepEqual(got, tt.want) {
+				t.Errorf("mergeSubsets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEndpointSources(t *testing.T) {
+	sources := endpointSources{
+		"cluster-b": {{Addresses: addresses("10.0.1.1", "10.0.1.2"), Ports: httpPorts}},
+		"cluster-a": {{Addresses: addresses("10.0.0.1"), Ports: httpPorts}},
+	}
+	endpoints := &v1.Endpoints{}
+	if err := setEndpointSources(endpoints, sources); err != nil {
+		t.Fatalf("setEndpointSources() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"round trip", getEndpointSources(endpoints), sources},
+		{"clusters", EndpointSourceClusters(endpoints), []string{"cluster-a", "cluster-b"}},
+		{"addresses", EndpointSourceAddresses(endpoints), map[string]int{"cluster-a": 1, "cluster-b": 2}},
+		{"owns remote address", sources.owns("10.0.1.2"), true},
+		{"owns local address", sources.owns("10.0.2.1"), false},
+		{"subsets", sources.subsets(nil), []v1.EndpointSubset{
+			{Addresses: addresses("10.0.0.1", "10.0.1.1", "10.0.1.2"), Ports: httpPorts},
+		}},
+		{"subsets with local", sources.subsets([]v1.EndpointSubset{{Addresses: addresses("10.0.2.1"), Ports: httpPorts}}), []v1.EndpointSubset{
+			{Addresses: addresses("10.0.2.1", "10.0.0.1", "10.0.1.1", "10.0.1.2"), Ports: httpPorts},
+		}},
+		{"nil endpoints", getEndpointSources(nil), endpointSources{}},
+		{"no annotation", getEndpointSources(&v1.Endpoints{}), endpointSources{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Errorf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+
+	if err := setEndpointSources(endpoints, nil); err != nil {
+		t.Fatalf("setEndpointSources() error = %v", err)
+	}
+	if _, ok := endpoints.Annotations[c.EP_ANNOTATION_SOURCES_KEY]; ok {
+		t.Errorf("setEndpointSources() kept the annotation without sources")
+	}
+}
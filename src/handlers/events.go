@@ -0,0 +1,63 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package handlers
+
+import (
+	c "github.com/vmware/k8s-endpoints-sync-controller/src/config"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons of the events recorded on the local services and endpoints.
+const (
+	eventReasonCreated     = "Replicated"
+	eventReasonUpdated     = "ReplicaUpdated"
+	eventReasonDeleted     = "ReplicaDeleted"
+	eventReasonModeChanged = "SyndicateModeChanged"
+	eventReasonFailed      = "ReplicationFailed"
+	// eventReasonInvalidTransition is recorded when a remote service asks
+	// for a syndicate mode the local service cannot change to.
+	eventReasonInvalidTransition = "InvalidSyndicateTransition"
+)
+
+const eventComponent = "endpoints-sync-controller"
+
+func newEventRecorder(kubeclient kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeclient.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: eventComponent})
+}
+
+// recordEvent records an event about obj caused by cluster, so that service
+// owners see what was replicated with kubectl describe. Nothing is recorded
+// in dry-run mode.
+func (s *ClusterDiscoveryHandler) recordEvent(cluster string, obj runtime.Object, eventtype string, reason string, action string) {
+	if s.config.DryRun || s.recorder == nil {
+		return
+	}
+	s.recorder.Eventf(obj, eventtype, reason, "%s from cluster %s", action, cluster)
+}
+
+// recordError records a warning about the failed verb on obj caused by
+// cluster.
+func (s *ClusterDiscoveryHandler) recordError(cluster string, obj runtime.Object, verb string, err error) {
+	if s.config.DryRun || s.recorder == nil {
+		return
+	}
+	s.recorder.Eventf(obj, v1.EventTypeWarning, eventReasonFailed, "Failed to %s replica from cluster %s: %v", verb, cluster, err)
+}
+
+// recordModeChange records the change of the syndicate mode of the local
+// service to mode, as requested by cluster.
+func (s *ClusterDiscoveryHandler) recordModeChange(cluster string, service *v1.Service, mode string) {
+	from := service.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY]
+	if from == "" {
+		from = "none"
+	}
+	s.recordEvent(cluster, service, v1.EventTypeNormal, eventReasonModeChanged, "Syndicate mode changed from "+from+" to "+mode)
+}
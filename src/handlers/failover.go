@@ -0,0 +1,173 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package handlers
+
+import (
+	"context"
+	c "github.com/vmware/k8s-endpoints-sync-controller/src/config"
+	"github.com/vmware/k8s-endpoints-sync-controller/src/log"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	informercorev1 "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"strings"
+	"time"
+)
+
+// A failover service keeps its pods behind its -syndicate service like a
+// union service, but its endpoints hold the ready addresses of the local
+// pods only, as long as there are any. Once none is ready they hold the
+// addresses of the remote clusters instead, until a local pod is ready again.
+
+// failoverResyncPeriod is the interval at which the endpoints of every
+// failover service are checked again.
+const failoverResyncPeriod = 10 * time.Minute
+
+// Reasons of the events recorded when a failover service switches between
+// local and remote addresses.
+const (
+	eventReasonFailoverActivated = "FailoverActivated"
+	eventReasonFailoverRecovered = "FailoverRecovered"
+)
+
+// WatchFailoverEndpoints follows the local endpoints of the -syndicate
+// services, for the endpoints of failover services to switch as soon as the
+// readiness of their local pods changes. It returns once stopCh is closed.
+func (s *ClusterDiscoveryHandler) WatchFailoverEndpoints(stopCh <-chan struct{}) {
+	// The endpoints of -syndicate services get their replicated=false label.
+	informer := informercorev1.NewFilteredEndpointsInformer(s.kubeclient, v1.NamespaceAll, failoverResyncPeriod, cache.Indexers{},
+		func(options *meta_v1.ListOptions) {
+			options.LabelSelector = c.REPLICATED_LABEL_KEY + "=false"
+		})
+	handle := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		endpoints, ok := obj.(*v1.Endpoints)
+		if !ok || !strings.HasSuffix(endpoints.Name, "-syndicate") {
+			return
+		}
+		if err := s.switchFailover(endpoints.Namespace, strings.TrimSuffix(endpoints.Name, "-syndicate")); err != nil {
+			log.Errorf("Error switching endpoints %s namespace %s, err %v", endpoints.Name, endpoints.Namespace, err)
+		}
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: handle,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			handle(newObj)
+		},
+		DeleteFunc: handle,
+	})
+	log.Infof("Watching endpoints of failover services")
+	informer.Run(stopCh)
+}
+
+// switchFailover updates the endpoints of the failover service name to the
+// addresses failoverSubsets selects.
+func (s *ClusterDiscoveryHandler) switchFailover(namespace string, name string) error {
+	existingService, err := s.kubeclient.CoreV1().Services(namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		log.Errorf("Error retrieving service obj, err %s", err)
+		return err
+	}
+	if existingService.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] != c.SVC_ANNOTATION_FAILOVER {
+		return nil
+	}
+	existingEndpoints, err := s.kubeclient.CoreV1().Endpoints(namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		log.Errorf("Error retrieving endpoints obj, err %s", err)
+		return err
+	}
+	endpointsToApply := existingEndpoints.DeepCopy()
+	endpointsToApply.Subsets = s.failoverSubsets(endpointsToApply, getEndpointSources(existingEndpoints))
+	if !s.changeInEndpoints(existingEndpoints, endpointsToApply) &&
+		existingEndpoints.Annotations[c.EP_ANNOTATION_FAILOVER_KEY] == endpointsToApply.Annotations[c.EP_ANNOTATION_FAILOVER_KEY] {
+		return nil
+	}
+	if err := s.updateEndpoints(s.localCluster(), endpointsToApply); err != nil {
+		log.Errorf("Error updating endpoint %s", err)
+		return err
+	}
+	s.recordFailover(existingEndpoints, endpointsToApply)
+	return nil
+}
+
+// failoverSubsets returns the subsets of endpoints of a failover service:
+// the ready local addresses of its -syndicate endpoints, or those of sources
+// when there are none. The EP_ANNOTATION_FAILOVER_KEY annotation of
+// endpoints is set while the remote addresses are used.
+func (s *ClusterDiscoveryHandler) failoverSubsets(endpoints *v1.Endpoints, sources endpointSources) []v1.EndpointSubset {
+	localSubsets := s.getReadyLocalSubsets(endpoints.Namespace, endpoints.Name+"-syndicate")
+	if len(localSubsets) > 0 {
+		delete(endpoints.Annotations, c.EP_ANNOTATION_FAILOVER_KEY)
+		return mergeSubsets(localSubsets)
+	}
+	if len(sources) > 0 {
+		setAnnotation(&endpoints.ObjectMeta, c.EP_ANNOTATION_FAILOVER_KEY, "true")
+	}
+	return sources.subsets(nil)
+}
+
+// recordFailover logs and records the switch of the endpoints of a failover
+// service from existing to applied, if any.
+func (s *ClusterDiscoveryHandler) recordFailover(existing *v1.Endpoints, applied *v1.Endpoints) {
+	failedOver := existing != nil && existing.Annotations[c.EP_ANNOTATION_FAILOVER_KEY] == "true"
+	switch {
+	case !failedOver && applied.Annotations[c.EP_ANNOTATION_FAILOVER_KEY] == "true":
+		log.Infof("no local address of endpoints %s namespace %s is ready, switched to clusters %v",
+			applied.Name, applied.Namespace, getEndpointSources(applied).clusters())
+		s.recordEvent(s.localCluster(), applied, v1.EventTypeWarning, eventReasonFailoverActivated, "No local address ready, switched to remote addresses")
+	case failedOver && applied.Annotations[c.EP_ANNOTATION_FAILOVER_KEY] != "true":
+		log.Infof("local addresses of endpoints %s namespace %s are ready, switched back", applied.Name, applied.Namespace)
+		s.recordEvent(s.localCluster(), applied, v1.EventTypeNormal, eventReasonFailoverRecovered, "Switched back to local addresses")
+	}
+}
+
+// getReadyLocalSubsets returns the ready addresses of the local endpoints
+// named name, nil if there are none.
+func (s *ClusterDiscoveryHandler) getReadyLocalSubsets(namespace string, name string) []v1.EndpointSubset {
+	localEndpoints, err := s.kubeclient.CoreV1().Endpoints(namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.Errorf("Error retrieving endpoints obj, err %s", err)
+		}
+		return nil
+	}
+	var readySubsets []v1.EndpointSubset
+	for _, v := range localEndpoints.Subsets {
+		if len(v.Addresses) == 0 {
+			continue
+		}
+		var endpointset v1.EndpointSubset
+		for _, address := range v.Addresses {
+			endpointAddress := v1.EndpointAddress{IP: address.IP}
+			if address.Hostname != "" {
+				endpointAddress.Hostname = address.Hostname
+			}
+			endpointset.Addresses = append(endpointset.Addresses, endpointAddress)
+		}
+		for _, port := range v.Ports {
+			endpointPort := v1.EndpointPort{Name: port.Name, Port: port.Port, Protocol: port.Protocol}
+			endpointset.Ports = append(endpointset.Ports, endpointPort)
+		}
+		readySubsets = append(readySubsets, endpointset)
+	}
+	return readySubsets
+}
+
+// localCluster names the local cluster in the events about failover
+// switches, which no remote cluster causes.
+func (s *ClusterDiscoveryHandler) localCluster() string {
+	if s.config.ClusterName != "" {
+		return s.config.ClusterName
+	}
+	return "local"
+}
@@ -0,0 +1,94 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package handlers
+
+import (
+	c "github.com/vmware/k8s-endpoints-sync-controller/src/config"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+)
+
+func TestFailoverSubsets(t *testing.T) {
+	sources := endpointSources{
+		"cluster-b": {{Addresses: addresses("10.1.0.1"), Ports: httpPorts}},
+		"cluster-c": {{Addresses: addresses("10.2.0.1"), Ports: httpPorts}},
+	}
+	remoteSubsets := []v1.EndpointSubset{{Addresses: addresses("10.1.0.1", "10.2.0.1"), Ports: httpPorts}}
+	localEndpoints := func(ready []v1.EndpointAddress, notReady []v1.EndpointAddress) *v1.Endpoints {
+		return &v1.Endpoints{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "api-syndicate", Namespace: "payments"},
+			Subsets:    []v1.EndpointSubset{{Addresses: ready, NotReadyAddresses: notReady, Ports: httpPorts}},
+		}
+	}
+
+	tests := []struct {
+		name         string
+		local        *v1.Endpoints
+		failedOver   bool
+		sources      endpointSources
+		want         []v1.EndpointSubset
+		wantFailover bool
+	}{
+		{
+			name:    "local addresses ready",
+			local:   localEndpoints(addresses("10.0.0.1", "10.0.0.2"), addresses("10.0.0.3")),
+			sources: sources,
+			want:    []v1.EndpointSubset{{Addresses: addresses("10.0.0.1", "10.0.0.2"), Ports: httpPorts}},
+		},
+		{
+			name:         "no local address ready",
+			local:        localEndpoints(nil, addresses("10.0.0.1")),
+			sources:      sources,
+			want:         remoteSubsets,
+			wantFailover: true,
+		},
+		{
+			name:         "no local endpoints",
+			sources:      sources,
+			want:         remoteSubsets,
+			wantFailover: true,
+		},
+		{
+			name:         "still failed over",
+			local:        localEndpoints(nil, addresses("10.0.0.1")),
+			failedOver:   true,
+			sources:      sources,
+			want:         remoteSubsets,
+			wantFailover: true,
+		},
+		{
+			name:       "switch back",
+			local:      localEndpoints(addresses("10.0.0.1"), nil),
+			failedOver: true,
+			sources:    sources,
+			want:       []v1.EndpointSubset{{Addresses: addresses("10.0.0.1"), Ports: httpPorts}},
+		},
+		{
+			name:  "no remote sources",
+			local: localEndpoints(nil, addresses("10.0.0.1")),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			if tt.local != nil {
+				client = fake.NewSimpleClientset(tt.local)
+			}
+			s := &ClusterDiscoveryHandler{kubeclient: client}
+			endpoints := &v1.Endpoints{ObjectMeta: meta_v1.ObjectMeta{Name: "api", Namespace: "payments"}}
+			if tt.failedOver {
+				endpoints.Annotations = map[string]string{c.EP_ANNOTATION_FAILOVER_KEY: "true"}
+			}
+			if got := s.failoverSubsets(endpoints, tt.sources); !equality.Semantic.DeepEqual(got, tt.want) {
+				t.Errorf("failoverSubsets() = %v, want %v", got, tt.want)
+			}
+			if failedOver := endpoints.Annotations[c.EP_ANNOTATION_FAILOVER_KEY] == "true"; failedOver != tt.wantFailover {
+				t.Errorf("failoverSubsets() failed over = %v, want %v", failedOver, tt.wantFailover)
+			}
+		})
+	}
+}
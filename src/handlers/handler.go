@@ -3,8 +3,22 @@
 
 package handlers
 
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+// Handler reconciles objects observed in the named remote cluster into the
+// local cluster. Both methods must be idempotent, a returned error requeues
+// the object so it is handled again later. RemoveCluster drops what was
+// replicated from a cluster that is no longer watched.
+//
+// DeleteReplica and RemoveEndpointsSource are used by the garbage collector
+// on local replicas, by their own namespace and name, with none of the
+// rules deciding what is replicated applied.
 type Handler interface {
-	ObjectCreated(obj interface{})
-	ObjectDeleted(obj interface{})
-	ObjectUpdated(oldObj, newObj interface{})
+	ObjectSynced(cluster string, obj interface{}) error
+	ObjectDeleted(cluster string, obj interface{}) error
+	RemoveCluster(cluster string) error
+	DeleteReplica(obj interface{}) error
+	RemoveEndpointsSource(cluster string, endpoints *v1.Endpoints) error
 }
@@ -0,0 +1,389 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	c "github.com/vmware/k8s-endpoints-sync-controller/src/config"
+	"github.com/vmware/k8s-endpoints-sync-controller/src/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strconv"
+	"strings"
+)
+
+// A syndicate transition brings the local copy of a remote service to the
+// mode the remote service requests, in steps. Each step gets the remote
+// service and the local service as it is before the step, and is safe to run
+// again.
+//
+// The steps of a transition changing the local mode depend on each other,
+// for instance the selector of a service must be kept on its -syndicate
+// service before it is removed. Their phase, the transition and step being
+// run, is kept in the SVC_ANNOTATION_PHASE_KEY annotation of the local
+// service until the last step is done, so that a step interrupted by a
+// restart is resumed. The other transitions are run again in full on the
+// next sync. A step returns errPending when it has to wait for the local
+// cluster, the transition is then resumed from that step on the next sync.
+type migrationStep func(s *ClusterDiscoveryHandler, cluster string, service *v1.Service, existing *v1.Service) error
+
+type syndicateTransition struct {
+	name string
+	// mode is the local syndicate mode the transition changes to, empty if
+	// it keeps the mode.
+	mode  string
+	steps []migrationStep
+}
+
+var syndicateTransitions = map[string]*syndicateTransition{}
+
+// errPending is returned by a step waiting for the local endpoints of the
+// service to be created.
+var errPending = errors.New("local endpoints do not exist yet")
+
+func addTransition(t *syndicateTransition) *syndicateTransition {
+	syndicateTransitions[t.name] = t
+	return t
+}
+
+var (
+	// enterUnion keeps the local pods of the service behind its -syndicate
+	// service and merges the addresses of all clusters in its endpoints.
+	enterUnion = addTransition(&syndicateTransition{
+		name: "enter-union",
+		mode: c.SVC_ANNOTATION_UNION,
+		steps: []migrationStep{
+			labelEndpoints("false"),
+			createSyndicateService,
+			markService(c.SVC_ANNOTATION_UNION, "false", true),
+		},
+	})
+	syncUnion = addTransition(&syndicateTransition{
+		name: "sync-union",
+		steps: []migrationStep{
+			labelEndpoints("false"),
+			createSyndicateService,
+			markService("", "true", true),
+		},
+	})
+	// enterFailover keeps the local pods of the service behind its
+	// -syndicate service like enterUnion, the endpoints then hold the
+	// addresses of the remote clusters only while no local pod is ready.
+	enterFailover = addTransition(&syndicateTransition{
+		name: "enter-failover",
+		mode: c.SVC_ANNOTATION_FAILOVER,
+		steps: []migrationStep{
+			labelEndpoints("false"),
+			createSyndicateService,
+			markService(c.SVC_ANNOTATION_FAILOVER, "false", true),
+			switchEndpoints,
+		},
+	})
+	syncFailover = addTransition(&syndicateTransition{
+		name: "sync-failover",
+		steps: []migrationStep{
+			labelEndpoints("false"),
+			createSyndicateService,
+			markService("", "true", true),
+		},
+	})
+	// becomeReceiver and becomeSource hand the local pods of the service
+	// over to the remote cluster, or take them over from it.
+	becomeReceiver = addTransition(&syndicateTransition{
+		name: "become-receiver",
+		mode: c.SVC_ANNOTATION_RECEIVER,
+		steps: []migrationStep{
+			labelEndpoints("false"),
+			markService(c.SVC_ANNOTATION_RECEIVER, "false", false),
+			deleteSyndicateService,
+		},
+	})
+	syncReceiver = addTransition(&syndicateTransition{
+		name: "sync-receiver",
+		steps: []migrationStep{
+			labelEndpoints("true"),
+			replicateLabels,
+		},
+	})
+	becomeSource = addTransition(&syndicateTransition{
+		name: "become-source",
+		mode: c.SVC_ANNOTATION_SOURCE,
+		steps: []migrationStep{
+			labelEndpoints("false"),
+			markService(c.SVC_ANNOTATION_SOURCE, "false", false),
+			deleteSyndicateService,
+		},
+	})
+	syncSource = addTransition(&syndicateTransition{
+		name: "sync-source",
+		steps: []migrationStep{
+			restoreSelector,
+			deleteSyndicateService,
+			labelEndpoints("false"),
+		},
+	})
+	// stopReplication deletes the replica of a service that became
+	// singular.
+	stopReplication = addTransition(&syndicateTransition{
+		name:  "stop",
+		mode:  c.SVC_ANNOTATION_SINGULAR,
+		steps: []migrationStep{deleteReplica},
+	})
+	replicate = addTransition(&syndicateTransition{
+		name:  "replicate",
+		steps: []migrationStep{updateReplica},
+	})
+)
+
+// syndicateTransitionFor returns the transition of the local existing
+// service to the mode service requests, nil if there is nothing to do. It
+// fails for transitions that are not allowed.
+func syndicateTransitionFor(existing *v1.Service, service *v1.Service) (*syndicateTransition, error) {
+	local := existing.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY]
+	remote := service.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY]
+	if local == c.SVC_ANNOTATION_SINGULAR && remote != c.SVC_ANNOTATION_SINGULAR {
+		return nil, fmt.Errorf("local service is %s, cannot change to mode %q", c.SVC_ANNOTATION_SINGULAR, remote)
+	}
+	switch remote {
+	case "":
+		return replicate, nil
+	case c.SVC_ANNOTATION_SINGULAR:
+		if existing.Labels[c.REPLICATED_LABEL_KEY] == "true" && local != c.SVC_ANNOTATION_SINGULAR {
+			return stopReplication, nil
+		}
+		return nil, nil
+	case c.SVC_ANNOTATION_UNION:
+		if local == c.SVC_ANNOTATION_UNION {
+			return syncUnion, nil
+		}
+		return enterUnion, nil
+	case c.SVC_ANNOTATION_FAILOVER:
+		if local == c.SVC_ANNOTATION_FAILOVER {
+			return syncFailover, nil
+		}
+		return enterFailover, nil
+	case c.SVC_ANNOTATION_SOURCE:
+		if local == c.SVC_ANNOTATION_RECEIVER {
+			return syncReceiver, nil
+		}
+		return becomeReceiver, nil
+	case c.SVC_ANNOTATION_RECEIVER:
+		if local == c.SVC_ANNOTATION_SOURCE {
+			return syncSource, nil
+		}
+		return becomeSource, nil
+	}
+	return nil, fmt.Errorf("unsupported mode %q", remote)
+}
+
+// runTransition runs the steps of t from step from on.
+func (s *ClusterDiscoveryHandler) runTransition(cluster string, service *v1.Service, existing *v1.Service, t *syndicateTransition, from int) error {
+	persist := t.mode != "" && len(t.steps) > 1
+	// A transition resumed at its first step has recorded its mode change.
+	if from == 0 && t.mode != "" && existing.Annotations[c.SVC_ANNOTATION_PHASE_KEY] == "" {
+		s.recordModeChange(cluster, existing, t.mode)
+	}
+	for i := from; i < len(t.steps); i++ {
+		if persist {
+			if err := s.setPhase(cluster, existing.Namespace, existing.Name, t.name+"/"+strconv.Itoa(i)); err != nil {
+				return err
+			}
+		}
+		if persist || i > from {
+			var err error
+			existing, err = s.kubeclient.CoreV1().Services(existing.Namespace).Get(context.TODO(), existing.Name, meta_v1.GetOptions{})
+			if err != nil {
+				log.Errorf("Error retrieving service obj, err %s", err)
+				return err
+			}
+		}
+		log.Debugf("service %s namespace %s: %s step %d", existing.Name, existing.Namespace, t.name, i)
+		if err := t.steps[i](s, cluster, service, existing); err != nil {
+			if err == errPending {
+				log.Infof("service %s namespace %s: %s step %d is pending, %v", existing.Name, existing.Namespace, t.name, i, err)
+			}
+			return err
+		}
+	}
+	if persist {
+		return s.setPhase(cluster, existing.Namespace, existing.Name, "")
+	}
+	return nil
+}
+
+// resumeTransition resumes the transition of the local existing service
+// interrupted at phase and returns the local service after it. An invalid
+// phase is dropped.
+func (s *ClusterDiscoveryHandler) resumeTransition(cluster string, service *v1.Service, existing *v1.Service, phase string) (*v1.Service, error) {
+	parts := strings.SplitN(phase, "/", 2)
+	t := syndicateTransitions[parts[0]]
+	step := -1
+	if len(parts) == 2 {
+		step, _ = strconv.Atoi(parts[1])
+	}
+	if t == nil || step < 0 || step >= len(t.steps) {
+		log.Errorf("Dropping invalid phase %q of service %s namespace %s", phase, existing.Name, existing.Namespace)
+		if err := s.setPhase(cluster, existing.Namespace, existing.Name, ""); err != nil {
+			return nil, err
+		}
+	} else {
+		log.Infof("resuming %s step %d of service %s namespace %s", t.name, step, existing.Name, existing.Namespace)
+		if err := s.runTransition(cluster, service, existing, t, step); err != nil {
+			return nil, err
+		}
+	}
+	existing, err := s.kubeclient.CoreV1().Services(existing.Namespace).Get(context.TODO(), existing.Name, meta_v1.GetOptions{})
+	if err != nil {
+		log.Errorf("Error retrieving service obj, err %s", err)
+		return nil, err
+	}
+	return existing, nil
+}
+
+// updateChangedService updates the local service existing, unless it is the
+// same as original.
+func (s *ClusterDiscoveryHandler) updateChangedService(cluster string, original *v1.Service, existing *v1.Service) error {
+	if equality.Semantic.DeepEqual(original, existing) {
+		return nil
+	}
+	if err := s.updateService(cluster, existing); err != nil {
+		log.Errorf("Error updating service %s", err)
+		return err
+	}
+	return nil
+}
+
+// setPhase sets the phase annotation of the local service, or removes it if
+// phase is empty.
+func (s *ClusterDiscoveryHandler) setPhase(cluster string, namespace string, name string, phase string) error {
+	existing, err := s.kubeclient.CoreV1().Services(namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+	if err != nil {
+		log.Errorf("Error retrieving service obj, err %s", err)
+		return err
+	}
+	if existing.Annotations[c.SVC_ANNOTATION_PHASE_KEY] == phase {
+		return nil
+	}
+	if phase == "" {
+		delete(existing.Annotations, c.SVC_ANNOTATION_PHASE_KEY)
+	} else {
+		setAnnotation(&existing.ObjectMeta, c.SVC_ANNOTATION_PHASE_KEY, phase)
+	}
+	if err := s.updateService(cluster, existing); err != nil {
+		log.Errorf("Error updating service %s", err)
+		return err
+	}
+	return nil
+}
+
+// labelEndpoints sets the replicated label of the local endpoints of the
+// service to value. It is pending while the endpoints do not exist.
+func labelEndpoints(value string) migrationStep {
+	return func(s *ClusterDiscoveryHandler, cluster string, service *v1.Service, existing *v1.Service) error {
+		existingEndpoints, err := s.kubeclient.CoreV1().Endpoints(service.Namespace).Get(context.TODO(), service.Name, meta_v1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return errPending
+		}
+		if err != nil {
+			log.Errorf("Error retrieving endpoints obj, err %s", err)
+			return err
+		}
+		if label, ok := existingEndpoints.Labels[c.REPLICATED_LABEL_KEY]; ok && label == value {
+			return nil
+		}
+		if existingEndpoints.Labels == nil {
+			existingEndpoints.Labels = map[string]string{}
+		}
+		existingEndpoints.Labels[c.REPLICATED_LABEL_KEY] = value
+		existingEndpoints.ResourceVersion = ""
+		if err := s.updateEndpoints(cluster, existingEndpoints); err != nil {
+			log.Errorf("Error updating endpoints %s", err)
+			return err
+		}
+		return nil
+	}
+}
+
+// markService sets the mode, if any, and the replicated label of the local
+// service, and drops its selector if dropSelector is set.
+func markService(mode string, replicated string, dropSelector bool) migrationStep {
+	return func(s *ClusterDiscoveryHandler, cluster string, service *v1.Service, existing *v1.Service) error {
+		original := existing.DeepCopy()
+		if mode != "" {
+			setAnnotation(&existing.ObjectMeta, c.SVC_ANNOTATION_SYNDICATE_KEY, mode)
+		}
+		if existing.Labels == nil {
+			existing.Labels = map[string]string{}
+		}
+		existing.Labels[c.REPLICATED_LABEL_KEY] = replicated
+		if dropSelector {
+			existing.Spec.Selector = nil
+		}
+		return s.updateChangedService(cluster, original, existing)
+	}
+}
+
+func createSyndicateService(s *ClusterDiscoveryHandler, cluster string, service *v1.Service, existing *v1.Service) error {
+	return s.handleServiceCreate(cluster, service.DeepCopy(), true)
+}
+
+func deleteSyndicateService(s *ClusterDiscoveryHandler, cluster string, service *v1.Service, existing *v1.Service) error {
+	syndicate := service.DeepCopy()
+	syndicate.Name = syndicate.Name + "-syndicate"
+	return s.handleServiceDelete(cluster, syndicate)
+}
+
+// restoreSelector moves the selector of the -syndicate service back to the
+// local service, before the -syndicate service is deleted.
+func restoreSelector(s *ClusterDiscoveryHandler, cluster string, service *v1.Service, existing *v1.Service) error {
+	original := existing.DeepCopy()
+	if selector := s.getSelectorfromSyndicateSvc(service); selector != nil {
+		existing.Spec.Selector = selector
+	}
+	if existing.Labels == nil {
+		existing.Labels = map[string]string{}
+	}
+	existing.Labels[c.REPLICATED_LABEL_KEY] = "false"
+	return s.updateChangedService(cluster, original, existing)
+}
+
+func replicateLabels(s *ClusterDiscoveryHandler, cluster string, service *v1.Service, existing *v1.Service) error {
+	original := existing.DeepCopy()
+	existing.Labels = map[string]string{}
+	for key, value := range service.Labels {
+		existing.Labels[key] = value
+	}
+	existing.Labels[c.REPLICATED_LABEL_KEY] = "true"
+	existing.Spec.Selector = nil
+	return s.updateChangedService(cluster, original, existing)
+}
+
+// switchEndpoints applies the failover rule to the endpoints right away
+// rather than on the next change of their addresses.
+func switchEndpoints(s *ClusterDiscoveryHandler, cluster string, service *v1.Service, existing *v1.Service) error {
+	return s.switchFailover(existing.Namespace, existing.Name)
+}
+
+func deleteReplica(s *ClusterDiscoveryHandler, cluster string, service *v1.Service, existing *v1.Service) error {
+	return s.handleServiceDelete(cluster, existing)
+}
+
+func updateReplica(s *ClusterDiscoveryHandler, cluster string, service *v1.Service, existing *v1.Service) error {
+	original := existing.DeepCopy()
+	existing.Spec.Ports = []v1.ServicePort{}
+	for _, port := range service.Spec.Ports {
+		existing.Spec.Ports = append(existing.Spec.Ports, v1.ServicePort{Protocol: port.Protocol, Name: port.Name, Port: port.Port, TargetPort: port.TargetPort})
+	}
+	existing.Labels = service.Labels
+	if existing.Labels == nil {
+		existing.Labels = map[string]string{}
+	}
+	existing.Labels[c.REPLICATED_LABEL_KEY] = s.config.ReplicatedLabelVal
+	setIPFamilies(existing, service)
+	return s.updateChangedService(cluster, original, existing)
+}
@@ -0,0 +1,72 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package handlers
+
+import (
+	c "github.com/vmware/k8s-endpoints-sync-controller/src/config"
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func serviceInMode(mode string, replicated string) *v1.Service {
+	service := &v1.Service{ObjectMeta: meta_v1.ObjectMeta{Name: "api", Namespace: "payments"}}
+	if mode != "" {
+		service.Annotations = map[string]string{c.SVC_ANNOTATION_SYNDICATE_KEY: mode}
+	}
+	if replicated != "" {
+		service.Labels = map[string]string{c.REPLICATED_LABEL_KEY: replicated}
+	}
+	return service
+}
+
+func TestSyndicateTransitionFor(t *testing.T) {
+	tests := []struct {
+		name       string
+		local      string
+		replicated string
+		remote     string
+		want       *syndicateTransition
+		wantErr    bool
+	}{
+		{name: "replica", replicated: "true", want: replicate},
+		{name: "enter union", replicated: "true", remote: c.SVC_ANNOTATION_UNION, want: enterUnion},
+		{name: "sync union", local: c.SVC_ANNOTATION_UNION, replicated: "true", remote: c.SVC_ANNOTATION_UNION, want: syncUnion},
+		{name: "enter failover", replicated: "true", remote: c.SVC_ANNOTATION_FAILOVER, want: enterFailover},
+		{name: "union to failover", local: c.SVC_ANNOTATION_UNION, replicated: "true", remote: c.SVC_ANNOTATION_FAILOVER, want: enterFailover},
+		{name: "sync failover", local: c.SVC_ANNOTATION_FAILOVER, replicated: "true", remote: c.SVC_ANNOTATION_FAILOVER, want: syncFailover},
+		{name: "become receiver", replicated: "false", remote: c.SVC_ANNOTATION_SOURCE, want: becomeReceiver},
+		{name: "union to receiver", local: c.SVC_ANNOTATION_UNION, replicated: "true", remote: c.SVC_ANNOTATION_SOURCE, want: becomeReceiver},
+		{name: "sync receiver", local: c.SVC_ANNOTATION_RECEIVER, replicated: "true", remote: c.SVC_ANNOTATION_SOURCE, want: syncReceiver},
+		{name: "become source", replicated: "true", remote: c.SVC_ANNOTATION_RECEIVER, want: becomeSource},
+		{name: "sync source", local: c.SVC_ANNOTATION_SOURCE, replicated: "false", remote: c.SVC_ANNOTATION_RECEIVER, want: syncSource},
+		{name: "receiver to source", local: c.SVC_ANNOTATION_RECEIVER, replicated: "true", remote: c.SVC_ANNOTATION_RECEIVER, want: becomeSource},
+		{name: "source to receiver", local: c.SVC_ANNOTATION_SOURCE, replicated: "false", remote: c.SVC_ANNOTATION_SOURCE, want: becomeReceiver},
+		{name: "receiver back to union", local: c.SVC_ANNOTATION_RECEIVER, replicated: "true", remote: c.SVC_ANNOTATION_UNION, want: enterUnion},
+		{name: "stop replica", replicated: "true", remote: c.SVC_ANNOTATION_SINGULAR, want: stopReplication},
+		{name: "stop local service", replicated: "false", remote: c.SVC_ANNOTATION_SINGULAR},
+		{name: "already singular", local: c.SVC_ANNOTATION_SINGULAR, replicated: "true", remote: c.SVC_ANNOTATION_SINGULAR},
+		{name: "singular to union", local: c.SVC_ANNOTATION_SINGULAR, remote: c.SVC_ANNOTATION_UNION, wantErr: true},
+		{name: "singular to replica", local: c.SVC_ANNOTATION_SINGULAR, wantErr: true},
+		{name: "unknown mode", replicated: "true", remote: "mirror", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := syndicateTransitionFor(serviceInMode(tt.local, tt.replicated), serviceInMode(tt.remote, ""))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("syndicateTransitionFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("syndicateTransitionFor() = %v, want %v", transitionName(got), transitionName(tt.want))
+			}
+		})
+	}
+}
+
+func transitionName(t *syndicateTransition) string {
+	if t == nil {
+		return "none"
+	}
+	return t.name
+}
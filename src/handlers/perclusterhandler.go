@@ -0,0 +1,270 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package handlers
+
+import (
+	"context"
+	c "github.com/vmware/k8s-endpoints-sync-controller/src/config"
+	"github.com/vmware/k8s-endpoints-sync-controller/src/log"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strings"
+)
+
+// perClusterName returns the name of the per-cluster replica of the service
+// or endpoints named name of cluster.
+func perClusterName(cluster string, name string) string {
+	return name + "-" + cluster
+}
+
+// perClusterEndpointSliceName returns the name of the per-cluster replica of
+// slice of cluster.
+func perClusterEndpointSliceName(cluster string, slice *discoveryv1.EndpointSlice) string {
+	return endpointSliceReplicaName(c.SERVICE_NAMING_PER_CLUSTER, cluster, slice)
+}
+
+// isPerClusterReplica reports whether labels are those of a per-cluster
+// replica.
+func isPerClusterReplica(objLabels map[string]string) bool {
+	return objLabels[c.REPLICA_NAMING_LABEL_KEY] == c.SERVICE_NAMING_PER_CLUSTER
+}
+
+// mergedReplica reports whether the deletion of the object with labels and
+// annotations is reconciled into the merged replicas. Deletions of
+// per-cluster replicas found by the garbage collector are not.
+func (s *ClusterDiscoveryHandler) mergedReplica(objLabels map[string]string, annotations map[string]string) bool {
+	return s.mergedNames(annotations) && !isPerClusterReplica(objLabels)
+}
+
+// perClusterDelete reports whether the deletion of the object with labels and
+// annotations is reconciled into the per-cluster replicas.
+func (s *ClusterDiscoveryHandler) perClusterDelete(objLabels map[string]string, annotations map[string]string) bool {
+	return s.perClusterNames(annotations) || isPerClusterReplica(objLabels)
+}
+
+func (s *ClusterDiscoveryHandler) perClusterLabels(cluster string, objLabels map[string]string) map[string]string {
+	replicaLabels := map[string]string{}
+	for k, v := range objLabels {
+		replicaLabels[k] = v
+	}
+	replicaLabels[c.REPLICATED_LABEL_KEY] = s.config.ReplicatedLabelVal
+	replicaLabels[c.REPLICA_NAMING_LABEL_KEY] = c.SERVICE_NAMING_PER_CLUSTER
+	replicaLabels[c.EPS_LABEL_SOURCE_CLUSTER_KEY] = cluster
+	return replicaLabels
+}
+
+// validPerClusterName reports whether name can be used for the per-cluster
+// replica of the service or endpoints named remoteName.
+func validPerClusterName(cluster string, namespace string, remoteName string, name string) bool {
+	if msgs := validation.IsDNS1035Label(name); len(msgs) > 0 {
+		log.Errorf("Cannot replicate %s namespace %s of cluster %s as %s, %s", remoteName, namespace, cluster, name, strings.Join(msgs, ", "))
+		return false
+	}
+	return true
+}
+
+// handlePerClusterService creates or updates the per-cluster replica of svc,
+// or deletes it if svc is singular. Existing services that are not
+// per-cluster replicas are left alone.
+func (s *ClusterDiscoveryHandler) handlePerClusterService(cluster string, svc *v1.Service) error {
+	if !s.perClusterNames(svc.Annotations) {
+		return nil
+	}
+	if svc.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] == c.SVC_ANNOTATION_SINGULAR {
+		return s.handlePerClusterServiceDelete(cluster, svc)
+	}
+	name := perClusterName(cluster, svc.Name)
+	if !validPerClusterName(cluster, svc.Namespace, svc.Name, name) {
+		return nil
+	}
+	log.Infof("updating service %s namespace %s from cluster %s", name, svc.Namespace, cluster)
+	existingService, err := s.kubeclient.CoreV1().Services(svc.Namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Errorf("Error retrieving service obj, err %s", err)
+		return err
+	}
+	exists := err == nil
+	service := &v1.Service{}
+	if exists {
+		if !isPerClusterReplica(existingService.Labels) {
+			log.Errorf("Not replacing service %s namespace %s, it is not a replica of cluster %s", name, svc.Namespace, cluster)
+			return nil
+		}
+		service = existingService
+	}
+	service.Name = name
+	service.Namespace = svc.Namespace
+	service.Labels = s.perClusterLabels(cluster, svc.Labels)
+	service.Spec.Selector = nil
+	service.Spec.Ports = []v1.ServicePort{}
+	for _, port := range svc.Spec.Ports {
+		service.Spec.Ports = append(service.Spec.Ports, v1.ServicePort{Protocol: port.Protocol, Name: port.Name, Port: port.Port, TargetPort: port.TargetPort})
+	}
+	setIPFamilies(service, svc)
+	if !exists {
+		if err := s.createService(cluster, service); err != nil {
+			log.Errorf("Error creating service %s", err)
+			return err
+		}
+		return nil
+	}
+	if err := s.updateService(cluster, service); err != nil {
+		log.Errorf("Error updating service %s", err)
+		return err
+	}
+	return nil
+}
+
+func (s *ClusterDiscoveryHandler) handlePerClusterServiceDelete(cluster string, svc *v1.Service) error {
+	if !s.perClusterDelete(svc.Labels, svc.Annotations) {
+		return nil
+	}
+	name := perClusterName(cluster, svc.Name)
+	existingService, err := s.kubeclient.CoreV1().Services(svc.Namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		log.Errorf("Error retrieving service obj, err %s", err)
+		return err
+	}
+	if !isPerClusterReplica(existingService.Labels) {
+		return nil
+	}
+	log.Infof("deleting service %s namespace %s from cluster %s", name, svc.Namespace, cluster)
+	if eErr := s.deleteService(cluster, svc.Namespace, name); eErr != nil {
+		log.Errorf("Error deleting service %v", eErr)
+		return eErr
+	}
+	return nil
+}
+
+// handlePerClusterEndpoints creates or updates the per-cluster replica of
+// endpoints with the addresses of the pods of cluster.
+func (s *ClusterDiscoveryHandler) handlePerClusterEndpoints(cluster string, endpoints *v1.Endpoints) error {
+	if !s.perClusterNames(endpoints.Annotations) || strings.HasSuffix(endpoints.Name, "-syndicate") {
+		return nil
+	}
+	name := perClusterName(cluster, endpoints.Name)
+	if !validPerClusterName(cluster, endpoints.Namespace, endpoints.Name, name) {
+		return nil
+	}
+	log.Debugf("updating endpoints %s namespace %s from cluster %s", name, endpoints.Namespace, cluster)
+	existingEndpoints, err := s.kubeclient.CoreV1().Endpoints(endpoints.Namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Errorf("Error retrieving endpoints obj, err %s", err)
+		return err
+	}
+	exists := err == nil
+	if exists && !isPerClusterReplica(existingEndpoints.Labels) {
+		log.Errorf("Not replacing endpoints %s namespace %s, it is not a replica of cluster %s", name, endpoints.Namespace, cluster)
+		return nil
+	}
+	endpointsToApply := &v1.Endpoints{}
+	endpointsToApply.Name = name
+	endpointsToApply.Namespace = endpoints.Namespace
+	endpointsToApply.Labels = s.perClusterLabels(cluster, endpoints.Labels)
+	endpointsToApply.Subsets = s.getClusterSubsets(cluster, endpoints)
+	if !exists {
+		if eErr := s.createEndpoints(cluster, endpointsToApply); eErr != nil {
+			log.Errorf("Error creating endpoint %s", eErr)
+			return eErr
+		}
+		return nil
+	}
+	endpointsToApply.ResourceVersion = existingEndpoints.ResourceVersion
+	if eErr := s.updateEndpoints(cluster, endpointsToApply); eErr != nil {
+		log.Errorf("Error updating endpoint %s", eErr)
+		return eErr
+	}
+	return nil
+}
+
+func (s *ClusterDiscoveryHandler) handlePerClusterEndpointsDelete(cluster string, endpoints *v1.Endpoints) error {
+	if !s.perClusterDelete(endpoints.Labels, endpoints.Annotations) || strings.HasSuffix(endpoints.Name, "-syndicate") {
+		return nil
+	}
+	name := perClusterName(cluster, endpoints.Name)
+	existingEndpoints, err := s.kubeclient.CoreV1().Endpoints(endpoints.Namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		log.Errorf("Error retrieving endpoints obj, err %s", err)
+		return err
+	}
+	if !isPerClusterReplica(existingEndpoints.Labels) {
+		return nil
+	}
+	log.Infof("deleting endpoints %s namespace %s from cluster %s", name, endpoints.Namespace, cluster)
+	if eErr := s.deleteEndpoints(cluster, endpoints.Namespace, name); eErr != nil {
+		log.Errorf("Error deleting endpoint %s", eErr)
+		return eErr
+	}
+	return nil
+}
+
+// handlePerClusterEndpointSlice creates or updates the per-cluster replica of
+// slice, which belongs to the per-cluster replica of its service.
+func (s *ClusterDiscoveryHandler) handlePerClusterEndpointSlice(cluster string, slice *discoveryv1.EndpointSlice) error {
+	if !s.perClusterNames(slice.Annotations) {
+		return nil
+	}
+	serviceName := perClusterName(cluster, slice.Labels[discoveryv1.LabelServiceName])
+	sliceToApply := s.getClusterEndpointSlice(cluster, slice, perClusterEndpointSliceName(cluster, slice), serviceName)
+	sliceToApply.Labels[c.REPLICA_NAMING_LABEL_KEY] = c.SERVICE_NAMING_PER_CLUSTER
+	return s.applyEndpointSlice(sliceToApply)
+}
+
+func (s *ClusterDiscoveryHandler) handlePerClusterEndpointSliceDelete(cluster string, slice *discoveryv1.EndpointSlice) error {
+	if !s.perClusterDelete(slice.Labels, slice.Annotations) {
+		return nil
+	}
+	name := perClusterEndpointSliceName(cluster, slice)
+	log.Infof("deleting endpointslice %s namespace %s from cluster %s", name, slice.Namespace, cluster)
+	if eErr := s.deleteEndpointSlice(slice.Namespace, name); eErr != nil {
+		log.Errorf("Error deleting endpointslice %s", eErr)
+		return eErr
+	}
+	return nil
+}
+
+// removeClusterPerClusterReplicas deletes the per-cluster replicas of the
+// services and endpoints of cluster, its endpointslices are deleted by
+// removeClusterEndpointSlices.
+func (s *ClusterDiscoveryHandler) removeClusterPerClusterReplicas(cluster string) error {
+	options := meta_v1.ListOptions{
+		LabelSelector: labels.Set{
+			c.REPLICA_NAMING_LABEL_KEY:     c.SERVICE_NAMING_PER_CLUSTER,
+			c.EPS_LABEL_SOURCE_CLUSTER_KEY: cluster,
+		}.AsSelector().String(),
+	}
+	services, err := s.kubeclient.CoreV1().Services(v1.NamespaceAll).List(context.TODO(), options)
+	if err != nil {
+		log.Errorf("Error listing services, err %s", err)
+		return err
+	}
+	for _, service := range services.Items {
+		if eErr := s.deleteService(cluster, service.Namespace, service.Name); eErr != nil {
+			log.Errorf("Error deleting service %v", eErr)
+			return eErr
+		}
+	}
+	endpointsList, err := s.kubeclient.CoreV1().Endpoints(v1.NamespaceAll).List(context.TODO(), options)
+	if err != nil {
+		log.Errorf("Error listing endpoints, err %s", err)
+		return err
+	}
+	for _, endpoints := range endpointsList.Items {
+		if eErr := s.deleteEndpoints(cluster, endpoints.Namespace, endpoints.Name); eErr != nil {
+			log.Errorf("Error deleting endpoint %s", eErr)
+			return eErr
+		}
+	}
+	return nil
+}
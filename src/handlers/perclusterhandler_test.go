@@ -0,0 +1,60 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package handlers
+
+import (
+	discoveryv1 "k8s.io/api/discovery/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func TestPerClusterName(t *testing.T) {
+	tests := []struct {
+		name    string
+		cluster string
+		remote  string
+		want    string
+	}{
+		{name: "service", cluster: "cluster-b", remote: "api", want: "api-cluster-b"},
+		{name: "syndicate service", cluster: "cluster-b", remote: "api-syndicate", want: "api-syndicate-cluster-b"},
+		{name: "dotted cluster", cluster: "eu.west", remote: "api", want: "api-eu.west"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := perClusterName(tt.cluster, tt.remote); got != tt.want {
+				t.Errorf("perClusterName(%q, %q) = %q, want %q", tt.cluster, tt.remote, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPerClusterEndpointSliceName(t *testing.T) {
+	slice := &discoveryv1.EndpointSlice{ObjectMeta: meta_v1.ObjectMeta{Name: "api-x7k2p"}}
+	if got, want := perClusterEndpointSliceName("cluster-b", slice), "api-x7k2p-cluster-b-50d97e4b"; got != want {
+		t.Errorf("perClusterEndpointSliceName() = %q, want %q", got, want)
+	}
+}
+
+func TestEndpointSliceNamesDistinct(t *testing.T) {
+	slices := []struct {
+		cluster string
+		slice   string
+	}{
+		{cluster: "b", slice: "api-x7k2p"},
+		{cluster: "x7k2p-b", slice: "api"},
+		{cluster: "b", slice: "api-x7k2p-b"},
+		{cluster: "x7k2p-b-b", slice: "api"},
+	}
+	names := map[string]string{}
+	for _, tt := range slices {
+		slice := &discoveryv1.EndpointSlice{ObjectMeta: meta_v1.ObjectMeta{Name: tt.slice}}
+		replica := tt.cluster + "/" + tt.slice
+		for _, name := range []string{localEndpointSliceName(tt.cluster, slice), perClusterEndpointSliceName(tt.cluster, slice)} {
+			if other, ok := names[name]; ok {
+				t.Errorf("replicas of %s and %s are both named %q", other, replica, name)
+			}
+			names[name] = replica
+		}
+	}
+}
@@ -0,0 +1,300 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package handlers
+
+import (
+	"fmt"
+	c "github.com/vmware/k8s-endpoints-sync-controller/src/config"
+	"github.com/vmware/k8s-endpoints-sync-controller/src/log"
+	"github.com/vmware/k8s-endpoints-sync-controller/src/replicationpolicy"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"sort"
+	"strings"
+	"time"
+)
+
+// policyResyncPeriod is the interval at which every ReplicationPolicy is
+// delivered again.
+const policyResyncPeriod = 10 * time.Minute
+
+// policy is a valid ReplicationPolicy with its parsed selector.
+type policy struct {
+	name     string
+	selector labels.Selector
+	spec     replicationpolicy.Spec
+}
+
+// WatchReplicationPolicies keeps the ReplicationPolicies of all namespaces
+// and calls onChange whenever one of them changes, for the services they
+// select to be replicated again. It returns once the policies are listed.
+func (s *ClusterDiscoveryHandler) WatchReplicationPolicies(onChange func(), stopCh <-chan struct{}) error {
+	dynamicClient, err := dynamic.NewForConfig(s.restConfig)
+	if err != nil {
+		log.Errorf("Error creating dynamic client with inclusterConfig, %s", err)
+		return err
+	}
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, policyResyncPeriod)
+	informer := factory.ForResource(replicationpolicy.Resource).Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			s.setPolicy(obj)
+			onChange()
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			// A resync delivers the policy again unchanged, the services it
+			// selects are left as they are.
+			oldPolicy, oldOK := oldObj.(*unstructured.Unstructured)
+			newPolicy, newOK := newObj.(*unstructured.Unstructured)
+			if oldOK && newOK && oldPolicy.GetResourceVersion() == newPolicy.GetResourceVersion() {
+				return
+			}
+			s.setPolicy(newObj)
+			onChange()
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if u, ok := obj.(*unstructured.Unstructured); ok {
+				s.removePolicy(u.GetNamespace(), u.GetName())
+				onChange()
+			}
+		},
+	})
+	log.Infof("Watching replication policies")
+	go informer.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+		return fmt.Errorf("replication policies did not sync")
+	}
+	return nil
+}
+
+func (s *ClusterDiscoveryHandler) setPolicy(obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	rp, err := replicationpolicy.FromUnstructured(u)
+	if err != nil {
+		log.Errorf("Error decoding replication policy %s namespace %s, err %v", u.GetName(), u.GetNamespace(), err)
+		s.removePolicy(u.GetNamespace(), u.GetName())
+		return
+	}
+	p, err := newPolicy(rp)
+	if err != nil {
+		log.Errorf("Ignoring replication policy %s namespace %s, %v", rp.Name, rp.Namespace, err)
+		s.removePolicy(rp.Namespace, rp.Name)
+		return
+	}
+	s.policiesLock.Lock()
+	defer s.policiesLock.Unlock()
+	policies := []policy{p}
+	for _, existing := range s.policies[rp.Namespace] {
+		if existing.name != p.name {
+			policies = append(policies, existing)
+		}
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].name < policies[j].name
+	})
+	s.policies[rp.Namespace] = policies
+}
+
+func (s *ClusterDiscoveryHandler) removePolicy(namespace string, name string) {
+	s.policiesLock.Lock()
+	defer s.policiesLock.Unlock()
+	var policies []policy
+	for _, existing := range s.policies[namespace] {
+		if existing.name != name {
+			policies = append(policies, existing)
+		}
+	}
+	if len(policies) == 0 {
+		delete(s.policies, namespace)
+		return
+	}
+	s.policies[namespace] = policies
+}
+
+func newPolicy(rp *replicationpolicy.ReplicationPolicy) (policy, error) {
+	selector, err := meta_v1.LabelSelectorAsSelector(&rp.Spec.ServiceSelector)
+	if err != nil {
+		return policy{}, fmt.Errorf("invalid serviceSelector: %v", err)
+	}
+	switch rp.Spec.Mode {
+	case "", c.SVC_ANNOTATION_SOURCE, c.SVC_ANNOTATION_RECEIVER, c.SVC_ANNOTATION_UNION, c.SVC_ANNOTATION_FAILOVER, c.SVC_ANNOTATION_SINGULAR:
+	default:
+		return policy{}, fmt.Errorf("unsupported mode %q", rp.Spec.Mode)
+	}
+	switch rp.Spec.ServiceNaming {
+	case "", c.SERVICE_NAMING_MERGED, c.SERVICE_NAMING_PER_CLUSTER, c.SERVICE_NAMING_BOTH:
+	default:
+		return policy{}, fmt.Errorf("unsupported serviceNaming %q", rp.Spec.ServiceNaming)
+	}
+	return policy{name: rp.Name, selector: selector, spec: rp.Spec}, nil
+}
+
+// servicePolicy returns the spec of the policy applying to the service with
+// svcLabels in the local namespace. The first matching policy by name wins.
+func (s *ClusterDiscoveryHandler) servicePolicy(namespace string, svcLabels map[string]string) *replicationpolicy.Spec {
+	s.policiesLock.RLock()
+	defer s.policiesLock.RUnlock()
+	for _, p := range s.policies[namespace] {
+		if p.selector.Matches(labels.Set(svcLabels)) {
+			spec := p.spec
+			return &spec
+		}
+	}
+	return nil
+}
+
+// policyDestination reports whether the service svc of cluster is replicated
+// to this cluster according to its policy.
+func (s *ClusterDiscoveryHandler) policyDestination(cluster string, svc *v1.Service) bool {
+	if !s.config.ReplicationPolicies {
+		return true
+	}
+	local, _ := s.config.LocalNamespace(cluster, svc.Namespace)
+	spec := s.servicePolicy(local, svc.Labels)
+	return spec == nil || spec.Destination(s.config.ClusterName)
+}
+
+// applyPolicy returns a copy of the local obj of cluster as its policy has
+// it replicated: with the syndicate mode of the policy unless annotated
+// otherwise, the naming of the policy and only the ports it allows.
+func (s *ClusterDiscoveryHandler) applyPolicy(cluster string, obj interface{}) interface{} {
+	switch v := obj.(type) {
+	case *v1.Service:
+		spec := s.servicePolicy(v.Namespace, v.Labels)
+		if spec == nil {
+			return obj
+		}
+		v = v.DeepCopy()
+		if spec.Mode != "" && v.Annotations[c.SVC_ANNOTATION_SYNDICATE_KEY] == "" {
+			setAnnotation(&v.ObjectMeta, c.SVC_ANNOTATION_SYNDICATE_KEY, spec.Mode)
+		}
+		setPolicyNaming(&v.ObjectMeta, spec)
+		var ports []v1.ServicePort
+		for _, port := range v.Spec.Ports {
+			if spec.PortAllowed(port.Name, port.Port) {
+				ports = append(ports, port)
+			}
+		}
+		v.Spec.Ports = ports
+		return v
+	case *v1.Endpoints:
+		remote := s.getRemoteService(cluster, v.Namespace, strings.TrimSuffix(v.Name, "-syndicate"))
+		spec := s.servicePolicy(v.Namespace, remote.labels)
+		if spec == nil {
+			return obj
+		}
+		v = v.DeepCopy()
+		setPolicyNaming(&v.ObjectMeta, spec)
+		var subsets []v1.EndpointSubset
+		for _, subset := range v.Subsets {
+			var ports []v1.EndpointPort
+			for _, port := range subset.Ports {
+				if spec.EndpointPortAllowed(port.Name, remote.ports) {
+					ports = append(ports, port)
+				}
+			}
+			if len(ports) > 0 {
+				subset.Ports = ports
+				subsets = append(subsets, subset)
+			}
+		}
+		v.Subsets = subsets
+		return v
+	case *discoveryv1.EndpointSlice:
+		remote := s.getRemoteService(cluster, v.Namespace, v.Labels[discoveryv1.LabelServiceName])
+		spec := s.servicePolicy(v.Namespace, remote.labels)
+		if spec == nil {
+			return obj
+		}
+		v = v.DeepCopy()
+		setPolicyNaming(&v.ObjectMeta, spec)
+		var ports []discoveryv1.EndpointPort
+		for _, port := range v.Ports {
+			var name string
+			if port.Name != nil {
+				name = *port.Name
+			}
+			if spec.EndpointPortAllowed(name, remote.ports) {
+				ports = append(ports, port)
+			}
+		}
+		v.Ports = ports
+		return v
+	}
+	return obj
+}
+
+func setAnnotation(objMeta *meta_v1.ObjectMeta, key string, value string) {
+	if objMeta.Annotations == nil {
+		objMeta.Annotations = map[string]string{}
+	}
+	objMeta.Annotations[key] = value
+}
+
+// setPolicyNaming marks the object with the naming of spec, which takes
+// precedence over ServiceNaming, see mergedNames and perClusterNames.
+func setPolicyNaming(objMeta *meta_v1.ObjectMeta, spec *replicationpolicy.Spec) {
+	if spec.ServiceNaming != "" {
+		setAnnotation(objMeta, c.POLICY_NAMING_ANNOTATION_KEY, spec.ServiceNaming)
+	}
+}
+
+// mergedNames and perClusterNames report how an object with annotations is
+// named, by its policy or otherwise by ServiceNaming.
+func (s *ClusterDiscoveryHandler) mergedNames(annotations map[string]string) bool {
+	if naming := annotations[c.POLICY_NAMING_ANNOTATION_KEY]; naming != "" {
+		return naming != c.SERVICE_NAMING_PER_CLUSTER
+	}
+	return s.config.MergedNames()
+}
+
+func (s *ClusterDiscoveryHandler) perClusterNames(annotations map[string]string) bool {
+	if naming := annotations[c.POLICY_NAMING_ANNOTATION_KEY]; naming != "" {
+		return naming == c.SERVICE_NAMING_PER_CLUSTER || naming == c.SERVICE_NAMING_BOTH
+	}
+	return s.config.PerClusterNames()
+}
+
+// remoteService is what the policies of endpoints need of their service.
+type remoteService struct {
+	labels map[string]string
+	ports  []v1.ServicePort
+}
+
+// getRemoteService returns the service named name in the local namespace of
+// cluster, as last seen.
+func (s *ClusterDiscoveryHandler) getRemoteService(cluster string, namespace string, name string) remoteService {
+	s.remoteServicesLock.RLock()
+	defer s.remoteServicesLock.RUnlock()
+	return s.remoteServices[serviceKey(cluster, namespace, name)]
+}
+
+func (s *ClusterDiscoveryHandler) setRemoteService(cluster string, svc *v1.Service) {
+	if !s.config.ReplicationPolicies {
+		return
+	}
+	local, _ := s.config.LocalNamespace(cluster, svc.Namespace)
+	s.remoteServicesLock.Lock()
+	defer s.remoteServicesLock.Unlock()
+	s.remoteServices[serviceKey(cluster, local, svc.Name)] = remoteService{labels: svc.Labels, ports: svc.Spec.Ports}
+}
+
+func (s *ClusterDiscoveryHandler) deleteRemoteService(cluster string, svc *v1.Service) {
+	local, _ := s.config.LocalNamespace(cluster, svc.Namespace)
+	s.remoteServicesLock.Lock()
+	defer s.remoteServicesLock.Unlock()
+	delete(s.remoteServices, serviceKey(cluster, local, svc.Name))
+}
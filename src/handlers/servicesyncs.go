@@ -0,0 +1,91 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package handlers
+
+import (
+	"fmt"
+	c "github.com/vmware/k8s-endpoints-sync-controller/src/config"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServiceSync is the outcome of the last replication of a local service from
+// any remote cluster.
+type ServiceSync struct {
+	LastSyncTime time.Time
+	LastError    string
+	// errorCluster is the cluster LastError came from, a later success from
+	// the same cluster clears it.
+	errorCluster string
+}
+
+var (
+	serviceSyncsLock sync.Mutex
+	serviceSyncs     = map[string]ServiceSync{}
+)
+
+// recordServiceSync records the outcome err of replicating obj of cluster
+// for the local service it belongs to.
+func recordServiceSync(cluster string, obj interface{}, err error) {
+	var namespace, name string
+	switch v := obj.(type) {
+	case *v1.Service:
+		namespace, name = v.Namespace, v.Name
+	case *v1.Endpoints:
+		namespace, name = v.Namespace, strings.TrimSuffix(v.Name, "-syndicate")
+	case *discoveryv1.EndpointSlice:
+		namespace, name = v.Namespace, v.Labels[discoveryv1.LabelServiceName]
+	default:
+		return
+	}
+	serviceSyncsLock.Lock()
+	defer serviceSyncsLock.Unlock()
+	serviceSync := serviceSyncs[namespace+"/"+name]
+	if err != nil {
+		serviceSync.LastError = fmt.Sprintf("cluster %s: %v", cluster, err)
+		serviceSync.errorCluster = cluster
+	} else {
+		serviceSync.LastSyncTime = time.Now()
+		if serviceSync.errorCluster == cluster {
+			serviceSync.LastError = ""
+			serviceSync.errorCluster = ""
+		}
+	}
+	serviceSyncs[namespace+"/"+name] = serviceSync
+}
+
+// ServiceSyncKey returns the key the syncs of the local service are recorded
+// under. Per-cluster replicas share the syncs of the remote service they are
+// named after.
+func ServiceSyncKey(service *v1.Service) string {
+	name := service.Name
+	if cluster := service.Labels[c.EPS_LABEL_SOURCE_CLUSTER_KEY]; cluster != "" && isPerClusterReplica(service.Labels) {
+		name = strings.TrimSuffix(name, "-"+cluster)
+	}
+	return service.Namespace + "/" + name
+}
+
+// GetServiceSync returns the outcome of the last replication of the local
+// service with the namespace/name key.
+func GetServiceSync(key string) (ServiceSync, bool) {
+	serviceSyncsLock.Lock()
+	defer serviceSyncsLock.Unlock()
+	serviceSync, ok := serviceSyncs[key]
+	return serviceSync, ok
+}
+
+// RetainServiceSyncs forgets the outcomes of all services but those with the
+// keys given.
+func RetainServiceSyncs(keys map[string]bool) {
+	serviceSyncsLock.Lock()
+	defer serviceSyncsLock.Unlock()
+	for key := range serviceSyncs {
+		if !keys[key] {
+			delete(serviceSyncs, key)
+		}
+	}
+}
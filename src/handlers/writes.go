@@ -0,0 +1,238 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/vmware/k8s-endpoints-sync-controller/src/log"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+// All writes to the local cluster go through the functions below. In dry-run
+// mode they log the intended change instead of calling the API server.
+// Writes to services and endpoints are recorded as events on them, naming the
+// cluster they were replicated from. An update the API server made no change
+// for, leaving the resource version as it was, is not recorded.
+
+func (s *ClusterDiscoveryHandler) createNamespace(namespace *v1.Namespace) error {
+	if s.config.DryRun {
+		return logDryRun("create", "namespaces", "", namespace.Name, nil, namespace, v1.Namespace{})
+	}
+	_, err := s.kubeclient.CoreV1().Namespaces().Create(context.TODO(), namespace, meta_v1.CreateOptions{})
+	return err
+}
+
+func (s *ClusterDiscoveryHandler) updateNamespace(namespace *v1.Namespace) error {
+	if s.config.DryRun {
+		existing, err := s.kubeclient.CoreV1().Namespaces().Get(context.TODO(), namespace.Name, meta_v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		return logDryRun("update", "namespaces", "", namespace.Name, existing, namespace, v1.Namespace{})
+	}
+	_, err := s.kubeclient.CoreV1().Namespaces().Update(context.TODO(), namespace, meta_v1.UpdateOptions{})
+	return err
+}
+
+func (s *ClusterDiscoveryHandler) deleteNamespace(name string) error {
+	if s.config.DryRun {
+		return logDryRun("delete", "namespaces", "", name, nil, nil, nil)
+	}
+	err := s.kubeclient.CoreV1().Namespaces().Delete(context.TODO(), name, meta_v1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *ClusterDiscoveryHandler) createService(cluster string, service *v1.Service) error {
+	if s.config.DryRun {
+		return logDryRun("create", "services", service.Namespace, service.Name, nil, service, v1.Service{})
+	}
+	created, err := s.kubeclient.CoreV1().Services(service.Namespace).Create(context.TODO(), service, meta_v1.CreateOptions{})
+	if err != nil {
+		s.recordError(cluster, service, "create", err)
+		return err
+	}
+	s.recordEvent(cluster, created, v1.EventTypeNormal, eventReasonCreated, "Created service")
+	return nil
+}
+
+func (s *ClusterDiscoveryHandler) updateService(cluster string, service *v1.Service) error {
+	if s.config.DryRun {
+		existing, err := s.kubeclient.CoreV1().Services(service.Namespace).Get(context.TODO(), service.Name, meta_v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		return logDryRun("update", "services", service.Namespace, service.Name, existing, service, v1.Service{})
+	}
+	updated, err := s.kubeclient.CoreV1().Services(service.Namespace).Update(context.TODO(), service, meta_v1.UpdateOptions{})
+	if err != nil {
+		s.recordError(cluster, service, "update", err)
+		return err
+	}
+	if resourceChanged(service.ObjectMeta, updated.ObjectMeta) {
+		s.recordEvent(cluster, updated, v1.EventTypeNormal, eventReasonUpdated, "Updated service")
+	}
+	return nil
+}
+
+func (s *ClusterDiscoveryHandler) deleteService(cluster string, namespace string, name string) error {
+	if s.config.DryRun {
+		return logDryRun("delete", "services", namespace, name, nil, nil, nil)
+	}
+	// The events are recorded on the live service, fetched before it is gone.
+	existing, err := s.kubeclient.CoreV1().Services(namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = s.kubeclient.CoreV1().Services(namespace).Delete(context.TODO(), name, uidPrecondition(existing.ObjectMeta))
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		s.recordError(cluster, existing, "delete", err)
+		return err
+	}
+	s.recordEvent(cluster, existing, v1.EventTypeNormal, eventReasonDeleted, "Deleted service")
+	return nil
+}
+
+func (s *ClusterDiscoveryHandler) createEndpoints(cluster string, endpoints *v1.Endpoints) error {
+	if s.config.DryRun {
+		return logDryRun("create", "endpoints", endpoints.Namespace, endpoints.Name, nil, endpoints, v1.Endpoints{})
+	}
+	created, err := s.kubeclient.CoreV1().Endpoints(endpoints.Namespace).Create(context.TODO(), endpoints, meta_v1.CreateOptions{})
+	if err != nil {
+		s.recordError(cluster, endpoints, "create", err)
+		return err
+	}
+	s.recordEvent(cluster, created, v1.EventTypeNormal, eventReasonCreated, "Created endpoints")
+	return nil
+}
+
+func (s *ClusterDiscoveryHandler) updateEndpoints(cluster string, endpoints *v1.Endpoints) error {
+	if s.config.DryRun {
+		existing, err := s.kubeclient.CoreV1().Endpoints(endpoints.Namespace).Get(context.TODO(), endpoints.Name, meta_v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		return logDryRun("update", "endpoints", endpoints.Namespace, endpoints.Name, existing, endpoints, v1.Endpoints{})
+	}
+	updated, err := s.kubeclient.CoreV1().Endpoints(endpoints.Namespace).Update(context.TODO(), endpoints, meta_v1.UpdateOptions{})
+	if err != nil {
+		s.recordError(cluster, endpoints, "update", err)
+		return err
+	}
+	if resourceChanged(endpoints.ObjectMeta, updated.ObjectMeta) {
+		s.recordEvent(cluster, updated, v1.EventTypeNormal, eventReasonUpdated, "Updated endpoints")
+	}
+	return nil
+}
+
+func (s *ClusterDiscoveryHandler) deleteEndpoints(cluster string, namespace string, name string) error {
+	if s.config.DryRun {
+		return logDryRun("delete", "endpoints", namespace, name, nil, nil, nil)
+	}
+	existing, err := s.kubeclient.CoreV1().Endpoints(namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = s.kubeclient.CoreV1().Endpoints(namespace).Delete(context.TODO(), name, uidPrecondition(existing.ObjectMeta))
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		s.recordError(cluster, existing, "delete", err)
+		return err
+	}
+	s.recordEvent(cluster, existing, v1.EventTypeNormal, eventReasonDeleted, "Deleted endpoints")
+	return nil
+}
+
+func (s *ClusterDiscoveryHandler) createEndpointSlice(slice *discoveryv1.EndpointSlice) error {
+	if s.config.DryRun {
+		return logDryRun("create", "endpointslices", slice.Namespace, slice.Name, nil, slice, discoveryv1.EndpointSlice{})
+	}
+	_, err := s.kubeclient.DiscoveryV1().EndpointSlices(slice.Namespace).Create(context.TODO(), slice, meta_v1.CreateOptions{})
+	return err
+}
+
+func (s *ClusterDiscoveryHandler) updateEndpointSlice(slice *discoveryv1.EndpointSlice) error {
+	if s.config.DryRun {
+		existing, err := s.kubeclient.DiscoveryV1().EndpointSlices(slice.Namespace).Get(context.TODO(), slice.Name, meta_v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		return logDryRun("update", "endpointslices", slice.Namespace, slice.Name, existing, slice, discoveryv1.EndpointSlice{})
+	}
+	_, err := s.kubeclient.DiscoveryV1().EndpointSlices(slice.Namespace).Update(context.TODO(), slice, meta_v1.UpdateOptions{})
+	return err
+}
+
+func (s *ClusterDiscoveryHandler) deleteEndpointSlice(namespace string, name string) error {
+	if s.config.DryRun {
+		return logDryRun("delete", "endpointslices", namespace, name, nil, nil, nil)
+	}
+	err := s.kubeclient.DiscoveryV1().EndpointSlices(namespace).Delete(context.TODO(), name, meta_v1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// resourceChanged reports whether the update of the object with meta to
+// updated changed it, the API server keeps the resource version of an object
+// an update makes no change to.
+func resourceChanged(meta meta_v1.ObjectMeta, updated meta_v1.ObjectMeta) bool {
+	return meta.ResourceVersion != updated.ResourceVersion
+}
+
+// uidPrecondition returns the options deleting the object with meta only,
+// not a newer object of the same name.
+func uidPrecondition(meta meta_v1.ObjectMeta) meta_v1.DeleteOptions {
+	return meta_v1.DeleteOptions{Preconditions: meta_v1.NewUIDPreconditions(string(meta.UID))}
+}
+
+// logDryRun logs a change that would have been made to the local cluster.
+// Creates carry the whole object, updates the strategic merge patch from
+// existing to desired and deletes only the name of the object.
+func logDryRun(verb string, resource string, namespace string, name string, existing interface{}, desired interface{}, dataStruct interface{}) error {
+	fields := []interface{}{"verb", verb, "resource", resource, "namespace", namespace, "name", name}
+	switch verb {
+	case "create":
+		b, err := json.Marshal(desired)
+		if err != nil {
+			return err
+		}
+		fields = append(fields, "object", json.RawMessage(b))
+	case "update":
+		existingJSON, err := json.Marshal(existing)
+		if err != nil {
+			return err
+		}
+		desiredJSON, err := json.Marshal(desired)
+		if err != nil {
+			return err
+		}
+		patch, err := strategicpatch.CreateTwoWayMergePatch(existingJSON, desiredJSON, dataStruct)
+		if err != nil {
+			return err
+		}
+		fields = append(fields, "diff", json.RawMessage(patch))
+	}
+	log.Infow("dry-run", fields...)
+	return nil
+}
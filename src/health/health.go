@@ -0,0 +1,121 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// ClusterState is the connection and informer sync state of a remote cluster.
+type ClusterState struct {
+	Name      string `json:"name"`
+	Connected bool   `json:"connected"`
+	Synced    bool   `json:"synced"`
+	Error     string `json:"error,omitempty"`
+}
+
+var (
+	lock     sync.RWMutex
+	clusters = map[string]*ClusterState{}
+)
+
+// Register adds cluster as not yet connected, readiness fails until it has
+// synced.
+func Register(cluster string) {
+	lock.Lock()
+	defer lock.Unlock()
+	clusters[cluster] = &ClusterState{Name: cluster}
+}
+
+// Remove stops reporting the state of cluster.
+func Remove(cluster string) {
+	lock.Lock()
+	defer lock.Unlock()
+	delete(clusters, cluster)
+}
+
+// SetError records that connecting to cluster failed with err.
+func SetError(cluster string, err error) {
+	update(cluster, func(state *ClusterState) {
+		state.Connected = false
+		state.Error = err.Error()
+	})
+}
+
+// SetConnected records that the API server of cluster is reachable.
+func SetConnected(cluster string) {
+	update(cluster, func(state *ClusterState) {
+		state.Connected = true
+		state.Error = ""
+	})
+}
+
+// SetSynced records that all informers of cluster have synced.
+func SetSynced(cluster string) {
+	update(cluster, func(state *ClusterState) {
+		state.Synced = true
+	})
+}
+
+func update(cluster string, f func(state *ClusterState)) {
+	lock.Lock()
+	defer lock.Unlock()
+	state, ok := clusters[cluster]
+	if !ok {
+		state = &ClusterState{Name: cluster}
+		clusters[cluster] = state
+	}
+	f(state)
+}
+
+// States returns the state of every registered cluster ordered by name.
+func States() []ClusterState {
+	lock.RLock()
+	defer lock.RUnlock()
+	states := make([]ClusterState, 0, len(clusters))
+	for _, state := range clusters {
+		states = append(states, *state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
+
+// Ready reports whether every registered cluster is connected and synced.
+func Ready() bool {
+	for _, state := range States() {
+		if !state.Connected || !state.Synced {
+			return false
+		}
+	}
+	return true
+}
+
+// HealthzHandler reports the state of every cluster and always succeeds
+// while the process is serving.
+func HealthzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeStates(w, http.StatusOK)
+	})
+}
+
+// ReadyzHandler reports the state of every cluster and fails until all of
+// them are connected and synced.
+func ReadyzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if Ready() {
+			writeStates(w, http.StatusOK)
+			return
+		}
+		writeStates(w, http.StatusServiceUnavailable)
+	})
+}
+
+func writeStates(w http.ResponseWriter, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(States())
+}
@@ -10,7 +10,8 @@ import (
 	"time"
 )
 
-var core zapcore.Core
+// core discards the entries logged before Initialize is called.
+var core zapcore.Core = zapcore.NewNopCore()
 
 func Initialize() error {
 
@@ -70,3 +71,24 @@ func Errorf(msg string, args ...interface{}) {
 	}
 	core.Write(e, nil)
 }
+
+// Sync flushes buffered log entries.
+func Sync() error {
+	return core.Sync()
+}
+
+// Infow writes msg at info level with the given alternating keys and values
+// as structured fields.
+func Infow(msg string, keysAndValues ...interface{}) {
+	var fields []zapcore.Field
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		fields = append(fields, zap.Any(fmt.Sprint(keysAndValues[i]), keysAndValues[i+1]))
+	}
+	e := zapcore.Entry{
+		Message:    msg,
+		Level:      zapcore.InfoLevel,
+		Time:       time.Now().UTC(),
+		LoggerName: "syndicate",
+	}
+	core.Write(e, fields)
+}
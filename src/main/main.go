@@ -4,11 +4,17 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	c "github.com/vmware/k8s-endpoints-sync-controller/src/config"
 	cc "github.com/vmware/k8s-endpoints-sync-controller/src/controller"
 	"github.com/vmware/k8s-endpoints-sync-controller/src/handlers"
+	"github.com/vmware/k8s-endpoints-sync-controller/src/health"
 	log "github.com/vmware/k8s-endpoints-sync-controller/src/log"
+	"github.com/vmware/k8s-endpoints-sync-controller/src/metrics"
 	"io/ioutil"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
@@ -25,58 +31,334 @@ func main() {
 		return
 	}
 
+	metrics.Register()
+	http.Handle("/metrics", metrics.Handler())
+	http.Handle("/healthz", health.HealthzHandler())
+	http.Handle("/readyz", health.ReadyzHandler())
+	server := &http.Server{Addr: config.MetricsAddr}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Errorf("failed to serve metrics %v", err)
+		}
+	}()
+
 	handler := &handlers.ClusterDiscoveryHandler{}
 	if handlerErr := handler.Init(config); handlerErr != nil {
 		log.Errorf("failed to initialize handler %v", handlerErr)
 		return
 	}
-	for _, cluster := range config.ClustersToWatch {
+	stop := make(chan struct{})
 
-		go cc.StartController(cluster, handler, config)
+	if config.ReplicationPolicies {
+		if err := handler.WatchReplicationPolicies(cc.RequeueServices, stop); err != nil {
+			log.Errorf("failed to watch replication policies %v", err)
+			return
+		}
+	}
 
+	leading := make(chan struct{})
+	clusters := cc.NewClusterManager(handler, config, leading)
+	if config.KubeconfigDir != "" {
+		go clusters.WatchKubeconfigDir(config.KubeconfigDir, stop)
+	}
+	if config.ClusterSecretSelector != "" {
+		if err := clusters.WatchClusterSecrets(config.ClusterSecretNamespace, config.ClusterSecretSelector, stop); err != nil {
+			log.Errorf("failed to watch cluster secrets %v", err)
+			return
+		}
+	}
+	if config.RemoteClusterNamespace != "" {
+		if err := clusters.WatchRemoteClusters(config.RemoteClusterNamespace, stop); err != nil {
+			log.Errorf("failed to watch remote clusters %v", err)
+			return
+		}
+	}
+	for _, cluster := range config.Clusters() {
+		clusters.Start(cluster)
+	}
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderDone := make(chan struct{})
+	if config.LeaderElection {
+		go func() {
+			defer close(leaderDone)
+			err := cc.RunLeaderElection(leaderCtx, config, func() { close(leading) }, func() {
+				if leaderCtx.Err() != nil {
+					return
+				}
+				log.Errorf("lost leadership, exiting")
+				os.Exit(1)
+			})
+			if err != nil {
+				log.Errorf("failed to run leader election %v", err)
+				os.Exit(1)
+			}
+		}()
+	} else {
+		close(leading)
+		close(leaderDone)
 	}
+	gcDone := make(chan struct{})
+	go func() {
+		defer close(gcDone)
+		select {
+		case <-leading:
+		case <-stop:
+			return
+		}
+		// Standby replicas replicate nothing, only the leader counts the
+		// replicated objects.
+		go wait.Until(handler.RecordReplicatedObjects, time.Minute, stop)
+		// Failover is not supported with endpointslices.
+		if !config.WatchEndpointSlices {
+			go handler.WatchFailoverEndpoints(stop)
+		}
+		if config.ServiceReplications {
+			go func() {
+				if err := cc.RunServiceReplications(config, stop); err != nil {
+					log.Errorf("failed to report service replications %v", err)
+				}
+			}()
+		}
+		if err := cc.RunGarbageCollector(handler, config, stop); err != nil {
+			log.Errorf("failed to run garbage collector %v", err)
+		}
+	}()
 
 	sigterm := make(chan os.Signal, 1)
 	signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
 	<-sigterm
+	shutdown(config, stop, clusters, gcDone, cancelLeader, leaderDone, server)
+}
+
+// shutdown stops watching remote clusters and waits up to ShutdownTimeout for
+// in-progress reconciles, and with FlushOnShutdown queued ones, to finish. The
+// Lease is released only afterwards so that no other replica writes while
+// this one still does.
+func shutdown(config *c.Config, stop chan struct{}, clusters *cc.ClusterManager, gcDone <-chan struct{},
+	cancelLeader context.CancelFunc, leaderDone <-chan struct{}, server *http.Server) {
+	log.Infof("Shutting down, waiting up to %v for in-progress reconciles", config.ShutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	close(stop)
+	for _, done := range []<-chan struct{}{clusters.Shutdown(), gcDone} {
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+	}
+	if ctx.Err() != nil {
+		log.Errorf("reconciles did not finish within %v", config.ShutdownTimeout)
+	}
+
+	cancelLeader()
+	select {
+	case <-leaderDone:
+	case <-time.After(config.RenewDeadline):
+		log.Errorf("failed to release the Lease within %v", config.RenewDeadline)
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		log.Errorf("failed to stop serving metrics %v", err)
+	}
+	log.Infof("Stopped clusterdiscovery controller")
+	log.Sync()
 }
 
+// DEFAULT_CONFIG_FILE is read when CONFIG_FILE is not set. It is optional, the
+// defaults and the environment are used when it does not exist.
+const DEFAULT_CONFIG_FILE = "/etc/syndicate/config.yaml"
+
 func loadConfig() (*c.Config, error) {
 
-	conf := &c.Config{}
+	file := c.DefaultFile()
+	path, pathExists := os.LookupEnv("CONFIG_FILE")
+	if !pathExists {
+		path = DEFAULT_CONFIG_FILE
+	}
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		log.Infof("Reading config file %s", path)
+		if file, err = c.ParseFile(data); err != nil {
+			log.Errorf("Error parsing config file %s %v", path, err)
+			return nil, err
+		}
+	} else if pathExists || !os.IsNotExist(err) {
+		log.Errorf("Error reading config file %v", err)
+		return nil, err
+	}
 
-	if n, nexists := os.LookupEnv("NSTOWATCH"); nexists {
-		conf.NamespaceToWatch = n
-	} else {
-		conf.NamespaceToWatch = ""
+	if err := overrideFromEnv(file); err != nil {
+		return nil, err
 	}
 
-	if e, eexists := os.LookupEnv("EXCLUDE"); eexists {
-		log.Infof("Namespaces to exclude %s", e)
-		conf.NamespacesToExclude = strings.Split(e, ",")
+	if p, pexists := os.LookupEnv("PODCIDRS"); pexists {
+		podCIDRs, err := parseClusterCIDRs(p)
+		if err != nil {
+			log.Errorf("Error parsing PODCIDRS %v", err)
+			return nil, err
+		}
+		file.ClusterPodCIDRs = podCIDRs
+		for i := range file.Clusters {
+			if cidrs, ok := podCIDRs[file.Clusters[i].Name]; ok {
+				file.Clusters[i].PodCIDRs = cidrs
+			}
+		}
 	}
 
-	searchDir := "/etc/kubeconfigs"
+	if file.LeaderElection.LeaseNamespace == "" {
+		file.LeaderElection.LeaseNamespace = ownNamespace()
+	}
+	if file.ClusterSecrets.Namespace == "" {
+		file.ClusterSecrets.Namespace = ownNamespace()
+	}
+	if file.RemoteClusters.Namespace == "" {
+		file.RemoteClusters.Namespace = ownNamespace()
+	}
+	if file.LeaderElection.Identity == "" {
+		if file.LeaderElection.Identity, err = os.Hostname(); err != nil {
+			log.Errorf("Error reading hostname %v", err)
+			return nil, err
+		}
+	}
 
-	files, err := ioutil.ReadDir(searchDir)
+	conf, err := file.Config()
 	if err != nil {
-		log.Errorf("Error reading dir %v", err)
+		log.Errorf("Invalid configuration %v", err)
 		return nil, err
 	}
+	for _, cluster := range conf.ClustersToWatch {
+		log.Infof("Kubeconfig of cluster to watch %s", cluster.KubeconfigPath)
+	}
+	if conf.DryRun {
+		log.Infof("Running in dry-run mode, no changes will be written")
+	}
+	return conf, nil
+}
 
-	for _, file := range files {
-		if !file.IsDir() && !strings.Contains(file.Name(), "data") {
-			log.Infof("Kubeconfig of cluster to watch %s", file.Name())
-			conf.ClustersToWatch = append(conf.ClustersToWatch, searchDir+"/"+file.Name())
-		}
+// ownNamespace returns the namespace the controller runs in.
+func ownNamespace() string {
+	if ns, nsexists := os.LookupEnv("POD_NAMESPACE"); nsexists {
+		return ns
 	}
+	if ns, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace"); err == nil {
+		return strings.TrimSpace(string(ns))
+	}
+	return "default"
+}
 
-	conf.ReplicatedLabelVal = "true"
+// overrideFromEnv applies the environment variables on top of the settings
+// of the config file.
+func overrideFromEnv(file *c.File) error {
+	var err error
+	if n, nexists := os.LookupEnv("NSTOWATCH"); nexists {
+		log.Infof("Namespaces to watch %s", n)
+		file.Namespaces.Watch = splitList(n)
+	}
+	if e, eexists := os.LookupEnv("EXCLUDE"); eexists {
+		log.Infof("Namespaces to exclude %s", e)
+		file.Namespaces.Exclude = splitList(e)
+	}
+	if d, dexists := os.LookupEnv("KUBECONFIG_DIR"); dexists {
+		file.KubeconfigDir = d
+	}
+	if l, lexists := os.LookupEnv("LOCAL_PODCIDRS"); lexists {
+		file.LocalPodCIDRs = splitList(l)
+	}
+	if m, mexists := os.LookupEnv("ENDPOINTSLICES"); mexists {
+		file.Watch.EndpointSlices = m == "true"
+		file.Watch.Endpoints = m != "true"
+	}
+	if l, lexists := os.LookupEnv("LEADER_ELECT"); lexists {
+		file.LeaderElection.Enabled = l == "true"
+	}
+	if ns, nsexists := os.LookupEnv("POD_NAMESPACE"); nsexists {
+		file.LeaderElection.LeaseNamespace = ns
+	}
+	if s, sexists := os.LookupEnv("CLUSTER_SECRETS"); sexists {
+		file.ClusterSecrets.Enabled = s == "true"
+	}
+	if s, sexists := os.LookupEnv("CLUSTER_SECRET_SELECTOR"); sexists {
+		file.ClusterSecrets.Selector = s
+	}
+	if r, rexists := os.LookupEnv("REMOTE_CLUSTERS"); rexists {
+		file.RemoteClusters.Enabled = r == "true"
+	}
+	if id, idexists := os.LookupEnv("LEADER_ELECTION_ID"); idexists {
+		file.LeaderElection.Identity = id
+	}
+	if d, dexists := os.LookupEnv("LEASE_DURATION"); dexists {
+		if file.LeaderElection.LeaseDuration.Duration, err = time.ParseDuration(d); err != nil {
+			log.Errorf("Error parsing LEASE_DURATION %v", err)
+			return err
+		}
+		file.LeaderElection.RenewDeadline.Duration = file.LeaderElection.LeaseDuration.Duration * 2 / 3
+	}
+	if a, aexists := os.LookupEnv("METRICS_ADDR"); aexists {
+		file.MetricsAddr = a
+	}
+	if d, dexists := os.LookupEnv("DRY_RUN"); dexists {
+		file.DryRun = d == "true"
+	}
+	if p, pexists := os.LookupEnv("REPLICATION_POLICIES"); pexists {
+		file.ReplicationPolicies = p == "true"
+	}
+	if n, nexists := os.LookupEnv("CLUSTER_NAME"); nexists {
+		file.ClusterName = n
+	}
+	if r, rexists := os.LookupEnv("SERVICE_REPLICATIONS"); rexists {
+		file.ServiceReplications = r == "true"
+	}
+	if e, eexists := os.LookupEnv("SERVICE_EXPORT_OPT_IN"); eexists {
+		file.ServiceExport.OptIn = e == "true"
+	}
+	if e, eexists := os.LookupEnv("SERVICE_EXPORT_SELECTOR"); eexists {
+		file.ServiceExport.Selector = e
+	}
+	if n, nexists := os.LookupEnv("SERVICE_NAMING"); nexists {
+		file.ServiceNaming = n
+	}
+	if g, gexists := os.LookupEnv("GC_PERIOD"); gexists {
+		if file.GCPeriod.Duration, err = time.ParseDuration(g); err != nil {
+			log.Errorf("Error parsing GC_PERIOD %v", err)
+			return err
+		}
+	}
+	if t, texists := os.LookupEnv("SHUTDOWN_TIMEOUT"); texists {
+		if file.ShutdownTimeout.Duration, err = time.ParseDuration(t); err != nil {
+			log.Errorf("Error parsing SHUTDOWN_TIMEOUT %v", err)
+			return err
+		}
+	}
+	if f, fexists := os.LookupEnv("FLUSH_ON_SHUTDOWN"); fexists {
+		file.FlushOnShutdown = f == "true"
+	}
+	return nil
+}
 
-	conf.WatchNamespaces = true
-	conf.WatchEndpoints = true
-	conf.WatchServices = true
-	conf.ResyncPeriod = 5 * time.Minute
+// parseClusterCIDRs parses pod CIDRs per cluster in the form
+// <cluster>=<cidr>,<cidr>;<cluster>=<cidr>, where <cluster> is the name of
+// the kubeconfig file of that cluster.
+func parseClusterCIDRs(val string) (map[string][]string, error) {
+	clusterCIDRs := map[string][]string{}
+	for _, entry := range strings.Split(val, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid cluster CIDRs %q, expected <cluster>=<cidr>[,<cidr>]", entry)
+		}
+		clusterCIDRs[strings.TrimSpace(parts[0])] = splitList(parts[1])
+	}
+	return clusterCIDRs, nil
+}
 
-	return conf, nil
+// splitList splits a comma separated list, dropping empty entries.
+func splitList(val string) []string {
+	var list []string
+	for _, entry := range strings.Split(val, ",") {
+		if strings.TrimSpace(entry) != "" {
+			list = append(list, strings.TrimSpace(entry))
+		}
+	}
+	return list
 }
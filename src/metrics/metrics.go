@@ -0,0 +1,171 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/util/workqueue"
+	"net/http"
+	"strings"
+)
+
+const namespace = "syndicate"
+
+var (
+	EventsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_received_total",
+		Help:      "Informer events received from remote clusters.",
+	}, []string{"cluster", "kind"})
+
+	APICalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_calls_total",
+		Help:      "Create, update and delete calls made to the local API server.",
+	}, []string{"verb", "resource"})
+
+	APIErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_errors_total",
+		Help:      "Create, update and delete calls to the local API server that failed.",
+	}, []string{"verb", "resource"})
+
+	ReplicatedObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "replicated_objects",
+		Help:      "Objects in the local cluster carrying the replicated label.",
+	}, []string{"resource"})
+
+	ReplicationLag = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "replication_lag_seconds",
+		Help:      "Time from a change of an object in a remote cluster to its replication in the local cluster.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{"cluster", "kind"})
+
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workqueue_depth",
+		Help:      "Current depth of the workqueue of each remote cluster.",
+	}, []string{"cluster"})
+
+	queueAdds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "workqueue_adds_total",
+		Help:      "Items added to the workqueue of each remote cluster.",
+	}, []string{"cluster"})
+
+	queueRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "workqueue_retries_total",
+		Help:      "Items requeued after a failed reconcile in the workqueue of each remote cluster.",
+	}, []string{"cluster"})
+)
+
+// Register registers all collectors and the workqueue metrics provider. It
+// must be called before any workqueue is created.
+func Register() {
+	prometheus.MustRegister(EventsReceived, APICalls, APIErrors, ReplicatedObjects, ReplicationLag,
+		queueDepth, queueAdds, queueRetries)
+	workqueue.SetProvider(queueMetricsProvider{})
+}
+
+// Handler serves the registered metrics.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// InstrumentTransport counts the create, update and delete requests sent
+// through rt and the ones that failed.
+func InstrumentTransport(rt http.RoundTripper) http.RoundTripper {
+	return &instrumentedTransport{rt: rt}
+}
+
+type instrumentedTransport struct {
+	rt http.RoundTripper
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	verb := verbForMethod(req.Method)
+	resp, err := t.rt.RoundTrip(req)
+	if verb == "" {
+		return resp, err
+	}
+	resource := resourceForPath(req.URL.Path)
+	APICalls.WithLabelValues(verb, resource).Inc()
+	if err != nil || resp.StatusCode >= http.StatusBadRequest {
+		APIErrors.WithLabelValues(verb, resource).Inc()
+	}
+	return resp, err
+}
+
+func verbForMethod(method string) string {
+	switch method {
+	case http.MethodPost:
+		return "create"
+	case http.MethodPut, http.MethodPatch:
+		return "update"
+	case http.MethodDelete:
+		return "delete"
+	}
+	return ""
+}
+
+// resourceForPath returns the resource of an API path such as
+// /api/v1/namespaces/<ns>/services/<name>.
+func resourceForPath(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i, part := range parts {
+		if part == "namespaces" {
+			if i+2 < len(parts) {
+				return parts[i+2]
+			}
+			return part
+		}
+	}
+	if len(parts) > 0 {
+		return parts[len(parts)-1]
+	}
+	return ""
+}
+
+// queueMetricsProvider exposes the depth, adds and retries of every named
+// workqueue, labelled by the name of the queue which is the cluster name.
+type queueMetricsProvider struct{}
+
+func (queueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return queueDepth.WithLabelValues(name)
+}
+
+func (queueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return queueAdds.WithLabelValues(name)
+}
+
+func (queueMetricsProvider) NewLatencyMetric(name string) workqueue.HistogramMetric {
+	return noopMetric{}
+}
+
+func (queueMetricsProvider) NewWorkDurationMetric(name string) workqueue.HistogramMetric {
+	return noopMetric{}
+}
+
+func (queueMetricsProvider) NewUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return noopMetric{}
+}
+
+func (queueMetricsProvider) NewLongestRunningProcessorSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return noopMetric{}
+}
+
+func (queueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return queueRetries.WithLabelValues(name)
+}
+
+type noopMetric struct{}
+
+func (noopMetric) Inc()            {}
+func (noopMetric) Dec()            {}
+func (noopMetric) Set(float64)     {}
+func (noopMetric) Observe(float64) {}
@@ -0,0 +1,100 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package remotecluster
+
+import (
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	Group   = "syndicate.vmware.com"
+	Version = "v1alpha1"
+	Kind    = "RemoteCluster"
+
+	// ConditionConnected is true while the API server of the cluster is
+	// reachable with the referenced credentials.
+	ConditionConnected = "Connected"
+	// ConditionSynced is true once all informers of the cluster have synced.
+	ConditionSynced = "Synced"
+
+	// DefaultKubeconfigKey is the key of the kubeconfig in the referenced
+	// Secret when none is given.
+	DefaultKubeconfigKey = "kubeconfig"
+)
+
+// Resource identifies the remoteclusters resource for the dynamic client.
+var Resource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "remoteclusters"}
+
+// RemoteCluster is a remote cluster whose objects are replicated into the
+// local cluster. Its name is the name of the cluster.
+type RemoteCluster struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   Spec   `json:"spec"`
+	Status Status `json:"status,omitempty"`
+}
+
+type Spec struct {
+	DisplayName         string          `json:"displayName,omitempty"`
+	KubeconfigSecretRef SecretReference `json:"kubeconfigSecretRef"`
+	PodCIDRs            []string        `json:"podCIDRs,omitempty"`
+	// NamespaceMapping maps namespaces of the cluster to the local
+	// namespaces their objects are replicated to.
+	NamespaceMapping map[string]string `json:"namespaceMapping,omitempty"`
+	Options          Options           `json:"options,omitempty"`
+}
+
+// SecretReference names a Secret in the namespace of the RemoteCluster and
+// the key holding the kubeconfig.
+type SecretReference struct {
+	Name string `json:"name"`
+	Key  string `json:"key,omitempty"`
+}
+
+// Options override the global settings for a single cluster.
+type Options struct {
+	// Paused stops replicating from the cluster while keeping what has
+	// been replicated so far.
+	Paused       bool             `json:"paused,omitempty"`
+	Workers      int              `json:"workers,omitempty"`
+	ResyncPeriod meta_v1.Duration `json:"resyncPeriod,omitempty"`
+}
+
+type Status struct {
+	ObservedGeneration int64               `json:"observedGeneration,omitempty"`
+	Conditions         []meta_v1.Condition `json:"conditions,omitempty"`
+	LastSyncTime       *meta_v1.Time       `json:"lastSyncTime,omitempty"`
+	ObjectCounts       map[string]int      `json:"objectCounts,omitempty"`
+	LastError          string              `json:"lastError,omitempty"`
+}
+
+// KubeconfigKey returns the key of the kubeconfig in the referenced Secret.
+func (ref SecretReference) KubeconfigKey() string {
+	if ref.Key == "" {
+		return DefaultKubeconfigKey
+	}
+	return ref.Key
+}
+
+// FromUnstructured converts an object returned by the dynamic client.
+func FromUnstructured(obj *unstructured.Unstructured) (*RemoteCluster, error) {
+	cluster := &RemoteCluster{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), cluster); err != nil {
+		return nil, err
+	}
+	return cluster, nil
+}
+
+// ToUnstructured converts cluster for the dynamic client.
+func ToUnstructured(cluster *RemoteCluster) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(cluster)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: content}, nil
+}
@@ -0,0 +1,108 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package replicationpolicy
+
+import (
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	Group   = "syndicate.vmware.com"
+	Version = "v1alpha1"
+	Kind    = "ReplicationPolicy"
+)
+
+// Resource identifies the replicationpolicies resource for the dynamic
+// client.
+var Resource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "replicationpolicies"}
+
+// ReplicationPolicy sets how the services it selects in its namespace are
+// replicated, in place of annotating every service.
+type ReplicationPolicy struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec Spec `json:"spec"`
+}
+
+type Spec struct {
+	ServiceSelector meta_v1.LabelSelector `json:"serviceSelector"`
+	// Mode is the syndicate mode of the selected services, their
+	// vmware.com/syndicate-mode annotation takes precedence.
+	Mode string `json:"mode,omitempty"`
+	// DestinationClusters are the names of the clusters the selected
+	// services are replicated to, all clusters if empty.
+	DestinationClusters []string `json:"destinationClusters,omitempty"`
+	// Ports are the names or numbers of the ports replicated, all ports if
+	// empty.
+	Ports []intstr.IntOrString `json:"ports,omitempty"`
+	// ServiceNaming overrides the naming of the replicas of the selected
+	// services.
+	ServiceNaming string `json:"serviceNaming,omitempty"`
+}
+
+// Destination reports whether the selected services are replicated to the
+// cluster named name.
+func (spec Spec) Destination(name string) bool {
+	if len(spec.DestinationClusters) == 0 {
+		return true
+	}
+	for _, destination := range spec.DestinationClusters {
+		if destination == name {
+			return true
+		}
+	}
+	return false
+}
+
+// PortAllowed reports whether the port named name with number is replicated.
+func (spec Spec) PortAllowed(name string, number int32) bool {
+	if len(spec.Ports) == 0 {
+		return true
+	}
+	for _, port := range spec.Ports {
+		if port.Type == intstr.String && port.StrVal == name && name != "" {
+			return true
+		}
+		if port.Type == intstr.Int && port.IntVal == number {
+			return true
+		}
+	}
+	return false
+}
+
+// EndpointPortAllowed reports whether the endpoint port named name is
+// replicated. Endpoint ports carry the target ports of the service, so they
+// are matched to servicePorts by name and filtered by the service port. Ports
+// of unknown services are matched by name only.
+func (spec Spec) EndpointPortAllowed(name string, servicePorts []v1.ServicePort) bool {
+	for _, port := range servicePorts {
+		if port.Name == name {
+			return spec.PortAllowed(port.Name, port.Port)
+		}
+	}
+	if len(spec.Ports) == 0 {
+		return true
+	}
+	for _, port := range spec.Ports {
+		if port.Type == intstr.String && port.StrVal == name && name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// FromUnstructured converts an object returned by the dynamic client.
+func FromUnstructured(obj *unstructured.Unstructured) (*ReplicationPolicy, error) {
+	policy := &ReplicationPolicy{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
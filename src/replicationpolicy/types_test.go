@@ -0,0 +1,88 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package replicationpolicy
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"testing"
+)
+
+func TestDestination(t *testing.T) {
+	tests := []struct {
+		name         string
+		destinations []string
+		cluster      string
+		want         bool
+	}{
+		{name: "all clusters", cluster: "cluster-a", want: true},
+		{name: "listed", destinations: []string{"cluster-a", "cluster-b"}, cluster: "cluster-b", want: true},
+		{name: "not listed", destinations: []string{"cluster-a"}, cluster: "cluster-b", want: false},
+		{name: "unnamed cluster", destinations: []string{"cluster-a"}, cluster: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := Spec{DestinationClusters: tt.destinations}
+			if got := spec.Destination(tt.cluster); got != tt.want {
+				t.Errorf("Destination(%q) = %v, want %v", tt.cluster, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPortAllowed(t *testing.T) {
+	ports := []intstr.IntOrString{intstr.FromString("http"), intstr.FromInt(9090)}
+	tests := []struct {
+		name   string
+		ports  []intstr.IntOrString
+		port   string
+		number int32
+		want   bool
+	}{
+		{name: "all ports", port: "grpc", number: 8443, want: true},
+		{name: "by name", ports: ports, port: "http", number: 80, want: true},
+		{name: "by number", ports: ports, port: "metrics", number: 9090, want: true},
+		{name: "not listed", ports: ports, port: "grpc", number: 8443, want: false},
+		{name: "unnamed", ports: []intstr.IntOrString{intstr.FromString("")}, port: "", number: 80, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := Spec{Ports: tt.ports}
+			if got := spec.PortAllowed(tt.port, tt.number); got != tt.want {
+				t.Errorf("PortAllowed(%q, %d) = %v, want %v", tt.port, tt.number, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEndpointPortAllowed(t *testing.T) {
+	servicePorts := []v1.ServicePort{
+		{Name: "http", Port: 80, TargetPort: intstr.FromInt(8080)},
+		{Name: "metrics", Port: 9090, TargetPort: intstr.FromInt(9090)},
+	}
+	tests := []struct {
+		name         string
+		ports        []intstr.IntOrString
+		port         string
+		servicePorts []v1.ServicePort
+		want         bool
+	}{
+		{name: "all ports", port: "http", servicePorts: servicePorts, want: true},
+		{name: "service port number", ports: []intstr.IntOrString{intstr.FromInt(80)}, port: "http", servicePorts: servicePorts, want: true},
+		{name: "target port number", ports: []intstr.IntOrString{intstr.FromInt(8080)}, port: "http", servicePorts: servicePorts, want: false},
+		{name: "by name", ports: []intstr.IntOrString{intstr.FromString("metrics")}, port: "metrics", servicePorts: servicePorts, want: true},
+		{name: "other port", ports: []intstr.IntOrString{intstr.FromInt(80)}, port: "metrics", servicePorts: servicePorts, want: false},
+		{name: "unknown service by name", ports: []intstr.IntOrString{intstr.FromString("http")}, port: "http", want: true},
+		{name: "unknown service by number", ports: []intstr.IntOrString{intstr.FromInt(80)}, port: "http", want: false},
+		{name: "unknown service all ports", port: "http", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := Spec{Ports: tt.ports}
+			if got := spec.EndpointPortAllowed(tt.port, tt.servicePorts); got != tt.want {
+				t.Errorf("EndpointPortAllowed(%q) = %v, want %v", tt.port, got, tt.want)
+			}
+		})
+	}
+}
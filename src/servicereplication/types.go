@@ -0,0 +1,80 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package servicereplication
+
+import (
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	Group   = "syndicate.vmware.com"
+	Version = "v1alpha1"
+	Kind    = "ServiceReplication"
+
+	// ConditionHealthy is true while the local endpoints of the service have
+	// addresses and its last replication succeeded.
+	ConditionHealthy = "Healthy"
+)
+
+// Resource identifies the servicereplications resource for the dynamic
+// client.
+var Resource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "servicereplications"}
+
+// ServiceReplication reports where the local service of the same name and
+// namespace is replicated from. It is owned and written by the controller.
+type ServiceReplication struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status Status `json:"status,omitempty"`
+}
+
+type Status struct {
+	// Mode is the syndicate mode of the local service, empty if it has none.
+	Mode           string              `json:"mode,omitempty"`
+	SourceClusters []SourceCluster     `json:"sourceClusters,omitempty"`
+	Destination    Destination         `json:"destination"`
+	Conditions     []meta_v1.Condition `json:"conditions,omitempty"`
+	LastSyncTime   *meta_v1.Time       `json:"lastSyncTime,omitempty"`
+	LastError      string              `json:"lastError,omitempty"`
+}
+
+// SourceCluster is a remote cluster contributing addresses to the service.
+type SourceCluster struct {
+	Name      string `json:"name"`
+	Addresses int    `json:"addresses"`
+}
+
+// Destination is the state of the service in the local cluster.
+type Destination struct {
+	// Replicated is set when the local service is a replica rather than a
+	// local service receiving remote addresses.
+	Replicated     bool `json:"replicated"`
+	EndpointsExist bool `json:"endpointsExist"`
+	// LocalAddresses counts the addresses of local pods, Addresses those of
+	// all clusters.
+	LocalAddresses int `json:"localAddresses"`
+	Addresses      int `json:"addresses"`
+}
+
+// FromUnstructured converts an object returned by the dynamic client.
+func FromUnstructured(obj *unstructured.Unstructured) (*ServiceReplication, error) {
+	replication := &ServiceReplication{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), replication); err != nil {
+		return nil, err
+	}
+	return replication, nil
+}
+
+// ToUnstructured converts replication for the dynamic client.
+func ToUnstructured(replication *ServiceReplication) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(replication)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: content}, nil
+}
@@ -5,6 +5,7 @@ package utils
 
 import (
 	c "github.com/vmware/k8s-endpoints-sync-controller/src/config"
+	"net"
 )
 
 func ContainsInArray(s []string, e string) bool {
@@ -33,3 +34,13 @@ func ContainsKeyVal(labels map[string]string, val string) bool {
 	}
 	return false
 }
+
+// NormalizeIP returns the canonical form of an IPv4 or IPv6 address, or an
+// empty string if ip is not a valid address.
+func NormalizeIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	return parsed.String()
+}
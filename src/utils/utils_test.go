@@ -0,0 +1,33 @@
+// Copyright © 2018 VMware, Inc. All Rights Reserved.
+// SPDX-License-Identifier: MIT
+
+package utils
+
+import (
+	"testing"
+)
+
+func TestNormalizeIP(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		want string
+	}{
+		{name: "ipv4", ip: "10.1.0.1", want: "10.1.0.1"},
+		{name: "ipv6", ip: "fd00:1::1", want: "fd00:1::1"},
+		{name: "ipv6 expanded", ip: "fd00:0001:0000:0000:0000:0000:0000:0001", want: "fd00:1::1"},
+		{name: "ipv6 upper case", ip: "FD00:1::AB", want: "fd00:1::ab"},
+		{name: "ipv4-mapped ipv6", ip: "::ffff:10.1.0.1", want: "10.1.0.1"},
+		{name: "ipv4-mapped ipv6 hex", ip: "::ffff:a01:1", want: "10.1.0.1"},
+		{name: "invalid", ip: "10.1.0", want: ""},
+		{name: "cidr", ip: "fd00:1::/64", want: ""},
+		{name: "empty", ip: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeIP(tt.ip); got != tt.want {
+				t.Errorf("NormalizeIP(%q) = %q, want %q", tt.ip, got, tt.want)
+			}
+		})
+	}
+}